@@ -35,11 +35,19 @@ type GenerateCmd struct {
 	OutPath  string            `arg:"-O,--out-path" help:"Path to the generated configuration file" placeholder:"PATH"`
 }
 
+type XtrabackupRestoreCmd struct {
+	TargetDir string   `arg:"-D,--target-dir,required" help:"Path to the directory for restored data" placeholder:"PATH"`
+	Binary    string   `arg:"-b,--binary" default:"xtrabackup" help:"Backup tool binary: xtrabackup or mariabackup" placeholder:"BINARY"`
+	EncKey    string   `arg:"-k,--encrypt-key-file" help:"Path to the key file used for backups encryption" placeholder:"PATH"`
+	Backups   []string `arg:"positional,required" help:"Backup archives in order: full (yearly) backup first, then monthly, decade and daily increments" placeholder:"BACKUP"`
+}
+
 type args struct {
-	Start    *StartCmd    `arg:"subcommand:start"`
-	Generate *GenerateCmd `arg:"subcommand:generate"`
-	ConfPath string       `arg:"-c,--config" help:"Path to config file" default:"/etc/nxs-backup/nxs-backup.conf" placeholder:"PATH"`
-	TestConf bool         `arg:"-t,--test-config" help:"Check if configuration correct"`
+	Start             *StartCmd             `arg:"subcommand:start"`
+	Generate          *GenerateCmd          `arg:"subcommand:generate"`
+	XtrabackupRestore *XtrabackupRestoreCmd `arg:"subcommand:xtrabackup-restore"`
+	ConfPath          string                `arg:"-c,--config" help:"Path to config file" default:"/etc/nxs-backup/nxs-backup.conf" placeholder:"PATH"`
+	TestConf          bool                  `arg:"-t,--test-config" help:"Check if configuration correct"`
 }
 
 // ReadArgs reads arguments from command line
@@ -19,6 +19,7 @@ type confOpts struct {
 	StorageConnects []storageConnect `conf:"storage_connects"`
 	IncludeCfgs     []string         `conf:"include_jobs_configs"`
 	WaitingTimeout  time.Duration    `conf:"waiting_timeout"`
+	SecretProviders secretProviders  `conf:"secret_providers"`
 
 	LogFile  string `conf:"logfile" conf_extraopts:"default=stdout"`
 	LogLevel string `conf:"loglevel" conf_extraopts:"default=info"`
@@ -37,7 +38,7 @@ type mailConf struct {
 	SmtpServer   string   `conf:"smtp_server"`
 	SmtpPort     int      `conf:"smtp_port"`
 	SmtpUser     string   `conf:"smtp_user"`
-	SmtpPassword string   `conf:"smtp_password"`
+	SmtpPassword string   `conf:"smtp_password" secret:"true"`
 	Recipients   []string `conf:"recipients"`
 	MessageLevel string   `conf:"message_level" conf_extraopts:"default=err"`
 }
@@ -53,15 +54,23 @@ type webhookConf struct {
 }
 
 type jobCfg struct {
-	JobName          string        `conf:"job_name" conf_extraopts:"required"`
-	JobType          string        `conf:"type" conf_extraopts:"required"`
-	TmpDir           string        `conf:"tmp_dir"`
-	SafetyBackup     bool          `conf:"safety_backup" conf_extraopts:"default=false"`
-	DeferredCopying  bool          `conf:"deferred_copying" conf_extraopts:"default=false"`
-	Sources          []sourceCfg   `conf:"sources"`
-	StoragesOptions  []storageOpts `conf:"storages_options"`
-	DumpCmd          string        `conf:"dump_cmd"`
-	SkipBackupRotate bool          `conf:"skip_backup_rotate" conf_extraopts:"default=false"` // used by external
+	JobName          string            `conf:"job_name" conf_extraopts:"required"`
+	JobType          string            `conf:"type" conf_extraopts:"required"`
+	TmpDir           string            `conf:"tmp_dir"`
+	SafetyBackup     bool              `conf:"safety_backup" conf_extraopts:"default=false"`
+	DeferredCopying  bool              `conf:"deferred_copying" conf_extraopts:"default=false"`
+	Sources          []sourceCfg       `conf:"sources"`
+	StoragesOptions  []storageOpts     `conf:"storages_options"`
+	DumpCmd          string            `conf:"dump_cmd"`
+	DumpCmdArgs      []string          `conf:"dump_cmd_args"`                               // used by external
+	Env              map[string]string `conf:"env"`                                         // used by external
+	EnvIsolated      bool              `conf:"env_isolated" conf_extraopts:"default=false"` // used by external
+	WorkDir          string            `conf:"work_dir"`                                    // used by external
+	RunAsUser        string            `conf:"run_as_user"`                                 // used by external
+	ParallelTargets  int               `conf:"parallel_targets" conf_extraopts:"default=1"`
+	Incremental      bool              `conf:"incremental" conf_extraopts:"default=false"`
+	SkipBackupRotate bool              `conf:"skip_backup_rotate" conf_extraopts:"default=false"` // used by external
+	StreamOutput     bool              `conf:"stream_output" conf_extraopts:"default=false"`      // used by external
 }
 
 type sourceCfg struct {
@@ -75,25 +84,92 @@ type sourceCfg struct {
 	ExcludeCollections []string      `conf:"exclude_collections"`
 	ExtraKeys          string        `conf:"db_extra_keys"`
 	IsSlave            bool          `conf:"is_slave" conf_extraopts:"default=false"`
+	SlaveMaxLag        int           `conf:"slave_max_lag" conf_extraopts:"default=0"`
+	SlaveCheckThreads  bool          `conf:"slave_check_threads" conf_extraopts:"default=false"`
+	SlaveCheckFailure  string        `conf:"slave_check_failure" conf_extraopts:"default=fail"`
+	StopSlaveSQLThread bool          `conf:"stop_slave_sql_thread_only" conf_extraopts:"default=false"`
 	Gzip               bool          `conf:"gzip" conf_extraopts:"default=false"`
 	SaveAbsPath        bool          `conf:"save_abs_path" conf_extraopts:"default=true"`
 	PrepareXtrabackup  bool          `conf:"prepare_xtrabackup" conf_extraopts:"default=false"`
+	XtrabackupStream   bool          `conf:"xtrabackup_stream" conf_extraopts:"default=false"`
+	XtrabackupBinary   string        `conf:"xtrabackup_binary" conf_extraopts:"default=xtrabackup"`
+	XtrabackupEncKey   string        `conf:"xtrabackup_encrypt_key_file"`
+	Format             string        `conf:"format" conf_extraopts:"default=plain"`
+	ParallelJobs       int           `conf:"parallel_jobs" conf_extraopts:"default=1"`
+	SplitTables        bool          `conf:"split_tables" conf_extraopts:"default=false"`
+	MongoDumpMode      string        `conf:"mongo_dump_mode" conf_extraopts:"default=collections"`
+	MongoOplog         bool          `conf:"mongo_oplog" conf_extraopts:"default=false"`
+	ClickhouseMethod   string        `conf:"clickhouse_backup_method" conf_extraopts:"default=auto"`
+	ClickhousePath     string        `conf:"clickhouse_backup_path" conf_extraopts:"default=/var/lib/clickhouse/backup"`
+	ESGlobalState      bool          `conf:"es_include_global_state" conf_extraopts:"default=false"`
+	ESRepoOwner        string        `conf:"es_repo_owner" conf_extraopts:"default=elasticsearch"`
+	ESSnapshotTimeout  time.Duration `conf:"es_snapshot_timeout" conf_extraopts:"default=43200"`
+	DockerVolumeLabels []string      `conf:"docker_volume_labels"`
+	DockerAction       string        `conf:"docker_container_action" conf_extraopts:"default=none"`
+	DockerCntrLabels   []string      `conf:"docker_container_labels"`
+	DockerExportCntrs  []string      `conf:"docker_export_containers"`
+	GitSkipUnchanged   bool          `conf:"git_skip_unchanged" conf_extraopts:"default=false"`
+	LdapMethod         string        `conf:"ldap_method" conf_extraopts:"default=slapcat"`
+	LdapSlapdConfig    string        `conf:"ldap_slapd_config"`
+	S3ChangedOnly      bool          `conf:"s3_changed_only" conf_extraopts:"default=false"`
 }
 
 type sourceConnect struct {
-	DBHost          string `conf:"db_host"`
-	DBPort          string `conf:"db_port"`
-	Socket          string `conf:"socket"`
-	DBUser          string `conf:"db_user"`
-	DBPassword      string `conf:"db_password"`
-	MySQLAuthFile   string `conf:"mysql_auth_file"`
-	PsqlSSLMode     string `conf:"psql_ssl_mode" conf_extraopts:"default=require"`
-	PsqlSSlRootCert string `conf:"psql_ssl_root_cert"`
-	PsqlSSlCrl      string `conf:"psql_ssl_crl"`
-	MongoRSName     string `conf:"mongo_replica_set_name"`
-	MongoRSAddr     string `conf:"mongo_replica_set_address"`
-	MongoTLSCAFile  string `conf:"mongo_tls_CA_file"`
-	MongoAuthDB     string `conf:"mongo_auth_db"`
+	DBHost              string   `conf:"db_host"`
+	DBPort              string   `conf:"db_port"`
+	Socket              string   `conf:"socket"`
+	DBUser              string   `conf:"db_user"`
+	DBPassword          string   `conf:"db_password" secret:"true"`
+	MySQLAuthFile       string   `conf:"mysql_auth_file"`
+	PsqlSSLMode         string   `conf:"psql_ssl_mode" conf_extraopts:"default=require"`
+	PsqlSSlRootCert     string   `conf:"psql_ssl_root_cert"`
+	PsqlSSlCrl          string   `conf:"psql_ssl_crl"`
+	MongoRSName         string   `conf:"mongo_replica_set_name"`
+	MongoRSAddr         string   `conf:"mongo_replica_set_address"`
+	MongoTLSCAFile      string   `conf:"mongo_tls_CA_file"`
+	MongoAuthDB         string   `conf:"mongo_auth_db"`
+	MongoReadPref       string   `conf:"mongo_read_preference"`
+	RedisTLS            bool     `conf:"redis_tls" conf_extraopts:"default=false"`
+	RedisTLSCAFile      string   `conf:"redis_tls_ca_file"`
+	RedisTLSCert        string   `conf:"redis_tls_cert_file"`
+	RedisTLSKey         string   `conf:"redis_tls_key_file"`
+	RedisTLSInsecure    bool     `conf:"redis_tls_insecure" conf_extraopts:"default=false"`
+	RedisSentinelAddrs  []string `conf:"redis_sentinel_addresses"`
+	RedisSentinelMaster string   `conf:"redis_sentinel_master"`
+	RedisSentinelPasswd string   `conf:"redis_sentinel_password" secret:"true"`
+	RedisPreferReplica  bool     `conf:"redis_sentinel_prefer_replica" conf_extraopts:"default=true"`
+	RedisCluster        bool     `conf:"redis_cluster" conf_extraopts:"default=false"`
+	EtcdEndpoints       []string `conf:"etcd_endpoints"`
+	EtcdTLSCAFile       string   `conf:"etcd_tls_ca_file"`
+	EtcdTLSCert         string   `conf:"etcd_tls_cert_file"`
+	EtcdTLSKey          string   `conf:"etcd_tls_key_file"`
+	EtcdTLSInsecure     bool     `conf:"etcd_tls_insecure" conf_extraopts:"default=false"`
+	ClickhouseSecure    bool     `conf:"clickhouse_secure" conf_extraopts:"default=false"`
+	ClickhouseInsecure  bool     `conf:"clickhouse_tls_insecure" conf_extraopts:"default=false"`
+	ClickhouseTCPPort   string   `conf:"clickhouse_native_port"`
+	ESSecure            bool     `conf:"es_secure" conf_extraopts:"default=false"`
+	ESTLSCAFile         string   `conf:"es_tls_ca_file"`
+	ESTLSInsecure       bool     `conf:"es_tls_insecure" conf_extraopts:"default=false"`
+	LdapURI             string   `conf:"ldap_uri"`
+	LdapStartTLS        bool     `conf:"ldap_starttls" conf_extraopts:"default=false"`
+	LdapTLSCAFile       string   `conf:"ldap_tls_ca_file"`
+	S3Endpoint          string   `conf:"s3_endpoint"`
+	S3AccessKeyID       string   `conf:"s3_access_key_id"`
+	S3SecretKey         string   `conf:"s3_secret_access_key" secret:"true"`
+	S3Secure            bool     `conf:"s3_secure" conf_extraopts:"default=true"`
+}
+
+type secretProviders struct {
+	Vault *vaultParams `conf:"vault"`
+}
+
+type vaultParams struct {
+	Address     string        `conf:"address" conf_extraopts:"required"`
+	Token       string        `conf:"token" secret:"true"`
+	Namespace   string        `conf:"namespace"`
+	KVVersion   int           `conf:"kv_version" conf_extraopts:"default=2"`
+	InsecureTLS bool          `conf:"insecure_tls" conf_extraopts:"default=false"`
+	Timeout     time.Duration `conf:"timeout" conf_extraopts:"default=10"`
 }
 
 type storageOpts struct {
@@ -117,12 +193,15 @@ type storageConnect struct {
 	NfsParams    *nfsParams    `conf:"nfs_params"`
 	WebDavParams *webDavParams `conf:"webdav_params"`
 	SmbParams    *smbParams    `conf:"smb_params"`
+	ExecParams   *execParams   `conf:"exec_params"`
+	AzureParams  *azureParams  `conf:"azure_params"`
+	GcsParams    *gcsParams    `conf:"gcs_params"`
 }
 
 type s3Params struct {
 	BucketName  string `conf:"bucket_name" conf_extraopts:"required"`
 	AccessKeyID string `conf:"access_key_id"`
-	SecretKey   string `conf:"secret_access_key"`
+	SecretKey   string `conf:"secret_access_key" secret:"true"`
 	Endpoint    string `conf:"endpoint" conf_extraopts:"required"`
 	Region      string `conf:"region" conf_extraopts:"required"`
 	Secure      bool   `conf:"secure" conf_extraopts:"default=true"`
@@ -132,7 +211,7 @@ type sftpParams struct {
 	User           string        `conf:"user" conf_extraopts:"required"`
 	Host           string        `conf:"host" conf_extraopts:"required"`
 	Port           int           `conf:"port" conf_extraopts:"default=22"`
-	Password       string        `conf:"password"`
+	Password       string        `conf:"password" secret:"true"`
 	KeyFile        string        `conf:"key_file"`
 	ConnectTimeout time.Duration `conf:"connection_timeout" conf_extraopts:"default=10"`
 }
@@ -140,7 +219,7 @@ type sftpParams struct {
 type ftpParams struct {
 	Host              string        `conf:"host"  conf_extraopts:"required"`
 	User              string        `conf:"user"`
-	Password          string        `conf:"password"`
+	Password          string        `conf:"password" secret:"true"`
 	Port              int           `conf:"port" conf_extraopts:"default=21"`
 	ConnectCount      int           `conf:"connect_count" conf_extraopts:"default=5"`
 	ConnectionTimeout time.Duration `conf:"connection_timeout" conf_extraopts:"default=10"`
@@ -156,8 +235,8 @@ type nfsParams struct {
 type webDavParams struct {
 	URL               string        `conf:"url" conf_extraopts:"required"`
 	Username          string        `conf:"username"`
-	Password          string        `conf:"password"`
-	OAuthToken        string        `conf:"oauth_token"`
+	Password          string        `conf:"password" secret:"true"`
+	OAuthToken        string        `conf:"oauth_token" secret:"true"`
 	ConnectionTimeout time.Duration `conf:"connection_timeout" conf_extraopts:"default=10"`
 }
 
@@ -165,12 +244,33 @@ type smbParams struct {
 	Host              string        `conf:"host" conf_extraopts:"required"`
 	Port              int           `conf:"port" conf_extraopts:"default=445"`
 	User              string        `conf:"user" conf_extraopts:"default=Guest"`
-	Password          string        `conf:"password"`
+	Password          string        `conf:"password" secret:"true"`
 	Domain            string        `conf:"domain"`
 	Share             string        `conf:"share" conf_extraopts:"required"`
 	ConnectionTimeout time.Duration `conf:"connection_timeout" conf_extraopts:"default=10"`
 }
 
+type azureParams struct {
+	AccountName string `conf:"account_name" conf_extraopts:"required"`
+	AccountKey  string `conf:"account_key" secret:"true"`
+	SASToken    string `conf:"sas_token" secret:"true"`
+	Container   string `conf:"container" conf_extraopts:"required"`
+	Endpoint    string `conf:"endpoint"`
+}
+
+type gcsParams struct {
+	Bucket          string `conf:"bucket_name" conf_extraopts:"required"`
+	CredentialsFile string `conf:"credentials_file"`
+	Endpoint        string `conf:"endpoint"`
+}
+
+type execParams struct {
+	Cmd     string            `conf:"cmd" conf_extraopts:"required"`
+	Args    []string          `conf:"args"`
+	Env     map[string]string `conf:"env"`
+	Timeout time.Duration     `conf:"timeout" conf_extraopts:"default=0"`
+}
+
 func confRead(confPath string) (confOpts, error) {
 
 	var c confOpts
@@ -198,6 +298,10 @@ func confRead(confPath string) (confOpts, error) {
 		}
 	}
 
+	if err = secretsResolve(&c); err != nil {
+		return c, err
+	}
+
 	return c, nil
 }
 
@@ -9,6 +9,7 @@ import (
 	appctx "github.com/nixys/nxs-go-appctx/v2"
 
 	"nxs-backup/interfaces"
+	"nxs-backup/misc"
 	"nxs-backup/modules/logger"
 )
 
@@ -67,9 +68,9 @@ func (c *Ctx) Init(opts appctx.CustomContextFuncOpts) (appctx.CfgData, error) {
 	}
 	for _, job := range c.Jobs {
 		switch job.GetType() {
-		case "desc_files", "inc_files":
+		case "desc_files", "inc_files", "docker_volumes", "git", "s3_bucket":
 			c.FilesJobs = append(c.FilesJobs, job)
-		case "mysql", "mysql_xtrabackup", "postgresql", "postgresql_basebackup", "mongodb", "redis":
+		case "mysql", "mysql_xtrabackup", "postgresql", "postgresql_basebackup", "mongodb", "redis", "sqlite", "etcd", "clickhouse", "elasticsearch", "ldap":
 			c.DBsJobs = append(c.DBsJobs, job)
 		case "external":
 			c.ExternalJobs = append(c.ExternalJobs, job)
@@ -108,8 +109,28 @@ func (c *Ctx) Free(opts appctx.CustomContextFuncOpts) int {
 
 	opts.Log.Debug("freeing context")
 
+	// the logging routine is already stopped, so records of the jobs and exit hooks are written directly
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case log := <-c.LogCh:
+				logger.WriteLog(opts.Log, log.Masked())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// running dumps are stopped first, so they don't write into removed temp paths,
+	// then the state changed by the jobs is restored while their connections are still open
+	misc.KillChildProcesses()
+	misc.RunExitHooks()
+	close(done)
+
 	_ = c.Jobs.Close()
 	_ = c.Storages.Close()
+	misc.CleanupTmpPaths()
 
 	return 0
 }
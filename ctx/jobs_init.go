@@ -8,20 +8,33 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"nxs-backup/interfaces"
+	"nxs-backup/modules/backup/clickhouse"
 	"nxs-backup/modules/backup/desc_files"
+	"nxs-backup/modules/backup/docker_volumes"
+	"nxs-backup/modules/backup/elasticsearch"
+	"nxs-backup/modules/backup/etcd"
 	"nxs-backup/modules/backup/external"
+	"nxs-backup/modules/backup/git"
 	"nxs-backup/modules/backup/inc_files"
+	"nxs-backup/modules/backup/ldap"
 	"nxs-backup/modules/backup/mongodump"
 	"nxs-backup/modules/backup/mysql"
 	"nxs-backup/modules/backup/mysql_xtrabackup"
 	"nxs-backup/modules/backup/psql"
 	"nxs-backup/modules/backup/psql_basebackup"
 	"nxs-backup/modules/backup/redis"
+	"nxs-backup/modules/backup/s3_bucket"
+	"nxs-backup/modules/backup/sqlite"
+	"nxs-backup/modules/connectors/clickhouse_connect"
+	"nxs-backup/modules/connectors/docker_connect"
+	"nxs-backup/modules/connectors/elastic_connect"
+	"nxs-backup/modules/connectors/etcd_connect"
 	"nxs-backup/modules/connectors/mongo_connect"
 	"nxs-backup/modules/connectors/mysql_connect"
 	"nxs-backup/modules/connectors/psql_connect"
 	"nxs-backup/modules/connectors/redis_connect"
 	"nxs-backup/modules/storage"
+	"nxs-backup/modules/storage/s3"
 )
 
 var AllowedJobTypes = []string{
@@ -34,6 +47,14 @@ var AllowedJobTypes = []string{
 	"mongodb",
 	"redis",
 	"external",
+	"sqlite",
+	"etcd",
+	"clickhouse",
+	"elasticsearch",
+	"docker_volumes",
+	"git",
+	"ldap",
+	"s3_bucket",
 }
 
 func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]interfaces.Job, error) {
@@ -132,7 +153,14 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 					Excludes:  src.Excludes,
 					Gzip:      src.Gzip,
 					IsSlave:   src.IsSlave,
-					ExtraKeys: extraKeys,
+					SlaveChecks: mysql.SlaveChecks{
+						MaxLag:         src.SlaveMaxLag,
+						CheckThreads:   src.SlaveCheckThreads,
+						OnFailure:      src.SlaveCheckFailure,
+						StopSQLThreads: src.StopSlaveSQLThread,
+					},
+					SplitTables: src.SplitTables,
+					ExtraKeys:   extraKeys,
 				})
 			}
 
@@ -142,6 +170,7 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 				NeedToMakeBackup: needToMakeBackup,
 				SafetyBackup:     j.SafetyBackup,
 				DeferredCopying:  j.DeferredCopying,
+				ParallelTargets:  j.ParallelTargets,
 				Storages:         jobStorages,
 				Sources:          sources,
 			})
@@ -169,13 +198,16 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 						Port:     src.Connect.DBPort,
 						Socket:   src.Connect.Socket,
 					},
-					Name:      src.Name,
-					TargetDBs: src.TargetDBs,
-					Excludes:  src.Excludes,
-					Gzip:      src.Gzip,
-					IsSlave:   src.IsSlave,
-					Prepare:   src.PrepareXtrabackup,
-					ExtraKeys: extraKeys,
+					Name:       src.Name,
+					TargetDBs:  src.TargetDBs,
+					Excludes:   src.Excludes,
+					Gzip:       src.Gzip,
+					IsSlave:    src.IsSlave,
+					Prepare:    src.PrepareXtrabackup,
+					Stream:     src.XtrabackupStream,
+					Binary:     src.XtrabackupBinary,
+					EncryptKey: src.XtrabackupEncKey,
+					ExtraKeys:  extraKeys,
 				})
 			}
 
@@ -185,6 +217,7 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 				NeedToMakeBackup: needToMakeBackup,
 				SafetyBackup:     j.SafetyBackup,
 				DeferredCopying:  j.DeferredCopying,
+				Incremental:      j.Incremental,
 				Storages:         jobStorages,
 				Sources:          sources,
 			})
@@ -219,7 +252,14 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 					Excludes:  src.Excludes,
 					Gzip:      src.Gzip,
 					IsSlave:   src.IsSlave,
-					ExtraKeys: extraKeys,
+					SlaveChecks: psql.SlaveChecks{
+						MaxLag:       src.SlaveMaxLag,
+						CheckThreads: src.SlaveCheckThreads,
+						OnFailure:    src.SlaveCheckFailure,
+					},
+					ExtraKeys:    extraKeys,
+					Format:       src.Format,
+					ParallelJobs: src.ParallelJobs,
 				})
 			}
 
@@ -299,6 +339,7 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 						RSAddr:    src.Connect.MongoRSAddr,
 						TLSCAFile: src.Connect.MongoTLSCAFile,
 						AuthDB:    src.Connect.MongoAuthDB,
+						ReadPref:  src.Connect.MongoReadPref,
 					},
 					Name:               src.Name,
 					Gzip:               src.Gzip,
@@ -307,6 +348,8 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 					TargetCollections:  src.TargetCollections,
 					ExcludeDBs:         src.ExcludeDBs,
 					ExcludeCollections: src.ExcludeCollections,
+					DumpMode:           src.MongoDumpMode,
+					Oplog:              src.MongoOplog,
 				})
 			}
 
@@ -331,13 +374,24 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 			for _, src := range j.Sources {
 				sources = append(sources, redis.SourceParams{
 					ConnectParams: redis_connect.Params{
-						Passwd: src.Connect.DBPassword,
-						Host:   src.Connect.DBHost,
-						Port:   src.Connect.DBPort,
-						Socket: src.Connect.Socket,
+						User:           src.Connect.DBUser,
+						Passwd:         src.Connect.DBPassword,
+						Host:           src.Connect.DBHost,
+						Port:           src.Connect.DBPort,
+						Socket:         src.Connect.Socket,
+						TLS:            src.Connect.RedisTLS,
+						TLSCAFile:      src.Connect.RedisTLSCAFile,
+						TLSCertFile:    src.Connect.RedisTLSCert,
+						TLSKeyFile:     src.Connect.RedisTLSKey,
+						TLSInsecure:    src.Connect.RedisTLSInsecure,
+						SentinelAddrs:  src.Connect.RedisSentinelAddrs,
+						SentinelMaster: src.Connect.RedisSentinelMaster,
+						SentinelPasswd: src.Connect.RedisSentinelPasswd,
 					},
-					Name: src.Name,
-					Gzip: src.Gzip,
+					Name:          src.Name,
+					Gzip:          src.Gzip,
+					Cluster:       src.Connect.RedisCluster,
+					PreferReplica: src.Connect.RedisPreferReplica,
 				})
 			}
 
@@ -359,11 +413,282 @@ func jobsInit(cfgJobs []jobCfg, storages map[string]interfaces.Storage) ([]inter
 		case AllowedJobTypes[8]:
 			job, err := external.Init(external.JobParams{
 				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
 				DumpCmd:          j.DumpCmd,
+				Args:             j.DumpCmdArgs,
+				Envs:             j.Env,
+				EnvIsolated:      j.EnvIsolated,
+				WorkDir:          j.WorkDir,
+				RunAsUser:        j.RunAsUser,
 				NeedToMakeBackup: needToMakeBackup,
 				SafetyBackup:     j.SafetyBackup,
 				SkipBackupRotate: j.SkipBackupRotate,
+				StreamOutput:     j.StreamOutput,
+				Storages:         jobStorages,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[9]:
+			var sources []sqlite.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, sqlite.SourceParams{
+					Name:     src.Name,
+					Targets:  src.Targets,
+					Excludes: src.Excludes,
+					Gzip:     src.Gzip,
+				})
+			}
+
+			job, err := sqlite.Init(sqlite.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
 				Storages:         jobStorages,
+				Sources:          sources,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[10]:
+			var sources []etcd.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, etcd.SourceParams{
+					ConnectParams: etcd_connect.Params{
+						Endpoints:   src.Connect.EtcdEndpoints,
+						User:        src.Connect.DBUser,
+						Passwd:      src.Connect.DBPassword,
+						TLSCAFile:   src.Connect.EtcdTLSCAFile,
+						TLSCertFile: src.Connect.EtcdTLSCert,
+						TLSKeyFile:  src.Connect.EtcdTLSKey,
+						TLSInsecure: src.Connect.EtcdTLSInsecure,
+					},
+					Name: src.Name,
+					Gzip: src.Gzip,
+				})
+			}
+
+			job, err := etcd.Init(etcd.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
+				Storages:         jobStorages,
+				Sources:          sources,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[11]:
+			var sources []clickhouse.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, clickhouse.SourceParams{
+					ConnectParams: clickhouse_connect.Params{
+						User:     src.Connect.DBUser,
+						Passwd:   src.Connect.DBPassword,
+						Host:     src.Connect.DBHost,
+						Port:     src.Connect.DBPort,
+						Secure:   src.Connect.ClickhouseSecure,
+						Insecure: src.Connect.ClickhouseInsecure,
+						TCPPort:  src.Connect.ClickhouseTCPPort,
+					},
+					Name:       src.Name,
+					TargetDBs:  src.TargetDBs,
+					Excludes:   src.Excludes,
+					Gzip:       src.Gzip,
+					Method:     src.ClickhouseMethod,
+					BackupPath: src.ClickhousePath,
+				})
+			}
+
+			job, err := clickhouse.Init(clickhouse.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
+				Storages:         jobStorages,
+				Sources:          sources,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[12]:
+			var sources []elasticsearch.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, elasticsearch.SourceParams{
+					ConnectParams: elastic_connect.Params{
+						User:        src.Connect.DBUser,
+						Passwd:      src.Connect.DBPassword,
+						Host:        src.Connect.DBHost,
+						Port:        src.Connect.DBPort,
+						Secure:      src.Connect.ESSecure,
+						TLSCAFile:   src.Connect.ESTLSCAFile,
+						TLSInsecure: src.Connect.ESTLSInsecure,
+					},
+					Name:               src.Name,
+					Targets:            src.Targets,
+					Excludes:           src.Excludes,
+					IncludeGlobalState: src.ESGlobalState,
+					RepoOwner:          src.ESRepoOwner,
+					SnapshotTimeout:    src.ESSnapshotTimeout,
+					Gzip:               src.Gzip,
+				})
+			}
+
+			job, err := elasticsearch.Init(elasticsearch.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
+				Storages:         jobStorages,
+				Sources:          sources,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[13]:
+			var sources []docker_volumes.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, docker_volumes.SourceParams{
+					ConnectParams: docker_connect.Params{
+						Socket: src.Connect.Socket,
+					},
+					Name:             src.Name,
+					Targets:          src.Targets,
+					Excludes:         src.Excludes,
+					VolumeLabels:     src.DockerVolumeLabels,
+					ContainerAction:  src.DockerAction,
+					ContainerLabels:  src.DockerCntrLabels,
+					ExportContainers: src.DockerExportCntrs,
+					Gzip:             src.Gzip,
+				})
+			}
+
+			job, err := docker_volumes.Init(docker_volumes.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
+				Storages:         jobStorages,
+				Sources:          sources,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[14]:
+			var sources []git.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, git.SourceParams{
+					Name:          src.Name,
+					Targets:       src.Targets,
+					Excludes:      src.Excludes,
+					SkipUnchanged: src.GitSkipUnchanged,
+					Gzip:          src.Gzip,
+				})
+			}
+
+			job, err := git.Init(git.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
+				Storages:         jobStorages,
+				Sources:          sources,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[15]:
+			var sources []ldap.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, ldap.SourceParams{
+					ConnectParams: ldap.ConnectParams{
+						URI:       src.Connect.LdapURI,
+						Host:      src.Connect.DBHost,
+						Port:      src.Connect.DBPort,
+						BindDN:    src.Connect.DBUser,
+						Passwd:    src.Connect.DBPassword,
+						StartTLS:  src.Connect.LdapStartTLS,
+						TLSCAFile: src.Connect.LdapTLSCAFile,
+					},
+					Name:        src.Name,
+					Method:      src.LdapMethod,
+					TargetDBs:   src.TargetDBs,
+					Excludes:    src.Excludes,
+					SlapdConfig: src.LdapSlapdConfig,
+					Gzip:        src.Gzip,
+				})
+			}
+
+			job, err := ldap.Init(ldap.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
+				Storages:         jobStorages,
+				Sources:          sources,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			jobs = append(jobs, job)
+
+		case AllowedJobTypes[16]:
+			var sources []s3_bucket.SourceParams
+			for _, src := range j.Sources {
+				sources = append(sources, s3_bucket.SourceParams{
+					ConnectParams: s3.Params{
+						AccessKeyID: src.Connect.S3AccessKeyID,
+						SecretKey:   src.Connect.S3SecretKey,
+						Endpoint:    src.Connect.S3Endpoint,
+						Secure:      src.Connect.S3Secure,
+					},
+					Name:        src.Name,
+					Targets:     src.Targets,
+					Excludes:    src.Excludes,
+					ChangedOnly: src.S3ChangedOnly,
+					Gzip:        src.Gzip,
+				})
+			}
+
+			job, err := s3_bucket.Init(s3_bucket.JobParams{
+				Name:             j.JobName,
+				TmpDir:           j.TmpDir,
+				NeedToMakeBackup: needToMakeBackup,
+				SafetyBackup:     j.SafetyBackup,
+				DeferredCopying:  j.DeferredCopying,
+				Storages:         jobStorages,
+				Sources:          sources,
 			})
 			if err != nil {
 				errs = multierror.Append(errs, err)
@@ -0,0 +1,117 @@
+package ctx
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+
+	"github.com/hashicorp/go-multierror"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/logger"
+	"nxs-backup/modules/secret/env"
+	"nxs-backup/modules/secret/file"
+	"nxs-backup/modules/secret/vault"
+)
+
+// secretRefRegex matches secret references like `${env:NAME}`, `${file:/run/secrets/x}` or `${vault:secret/db#password}`
+var secretRefRegex = regexp.MustCompile(`\$\{(env|file|vault):([^}]+)}`)
+
+// noResolveFields are config fields passed to shell commands as is, so `${...}` in them belongs to the shell
+var noResolveFields = []string{"dump_cmd", "dump_cmd_args"}
+
+type secretResolver struct {
+	providers map[string]interfaces.SecretProvider
+	errs      *multierror.Error
+}
+
+// secretsResolve replaces secret references in all string values of the config with the secrets
+func secretsResolve(c *confOpts) error {
+
+	r := &secretResolver{providers: make(map[string]interfaces.SecretProvider)}
+	for _, p := range []interfaces.SecretProvider{env.Init(), file.Init()} {
+		r.providers[p.GetName()] = p
+	}
+
+	if c.SecretProviders.Vault != nil {
+		// Vault connection params may refer to env and file secrets only
+		r.resolveValue(reflect.ValueOf(c.SecretProviders.Vault).Elem())
+		if r.errs != nil {
+			return r.errs
+		}
+
+		v, err := vault.Init(vault.Params(*c.SecretProviders.Vault))
+		if err != nil {
+			return err
+		}
+		r.providers[v.GetName()] = v
+	}
+
+	r.resolveValue(reflect.ValueOf(c).Elem())
+
+	return r.errs.ErrorOrNil()
+}
+
+func (r *secretResolver) resolveValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(r.resolveString(v.String()))
+		}
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			r.resolveValue(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() || misc.Contains(noResolveFields, field.Tag.Get("conf")) {
+				continue
+			}
+			r.resolveValue(v.Field(i))
+			// credentials are masked in logs whether they are defined as references or as plain text
+			if field.Tag.Get("secret") == "true" && v.Field(i).Kind() == reflect.String {
+				logger.AddSecret(v.Field(i).String())
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			r.resolveValue(v.Index(i))
+		}
+	case reflect.Map:
+		// map values aren't addressable, so resolved values are set back to the map
+		for _, k := range v.MapKeys() {
+			val := v.MapIndex(k)
+			if val.Kind() == reflect.Interface && !val.IsNil() {
+				val = val.Elem()
+			}
+			if val.Kind() == reflect.String {
+				v.SetMapIndex(k, reflect.ValueOf(r.resolveString(val.String())).Convert(val.Type()))
+			} else {
+				r.resolveValue(val)
+			}
+		}
+	}
+}
+
+func (r *secretResolver) resolveString(s string) string {
+	return secretRefRegex.ReplaceAllStringFunc(s, func(ref string) string {
+		m := secretRefRegex.FindStringSubmatch(ref)
+
+		p, ok := r.providers[m[1]]
+		if !ok {
+			r.errs = multierror.Append(r.errs, fmt.Errorf("secret provider `%s` isn't configured for `%s`", m[1], ref))
+			return ref
+		}
+
+		secret, err := p.GetSecret(m[2])
+		if err != nil {
+			r.errs = multierror.Append(r.errs, fmt.Errorf("unable to resolve secret `%s`: %s", ref, err))
+			return ref
+		}
+		logger.AddSecret(secret)
+
+		return secret
+	})
+}
@@ -7,7 +7,10 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"nxs-backup/interfaces"
+	"nxs-backup/modules/storage/azure"
+	"nxs-backup/modules/storage/exec_plugin"
 	"nxs-backup/modules/storage/ftp"
+	"nxs-backup/modules/storage/gcs"
 	"nxs-backup/modules/storage/local"
 	"nxs-backup/modules/storage/nfs"
 	"nxs-backup/modules/storage/s3"
@@ -24,6 +27,9 @@ var allowedConnectParams = []string{
 	"smb_params",
 	"nfs_params",
 	"webdav_params",
+	"exec_params",
+	"azure_params",
+	"gcs_params",
 }
 
 func storagesInit(conf confOpts) (storagesMap map[string]interfaces.Storage, err error) {
@@ -80,6 +86,29 @@ func storagesInit(conf confOpts) (storagesMap map[string]interfaces.Storage, err
 				errs = multierror.Append(errs, err)
 			}
 
+		} else if st.ExecParams != nil {
+			storagesMap[st.Name], err = exec_plugin.Init(st.Name, exec_plugin.Params{
+				Cmd:     st.ExecParams.Cmd,
+				Args:    st.ExecParams.Args,
+				Envs:    st.ExecParams.Env,
+				Timeout: st.ExecParams.Timeout,
+			})
+			if err != nil {
+				errs = multierror.Append(errs, err)
+			}
+
+		} else if st.AzureParams != nil {
+			storagesMap[st.Name], err = azure.Init(st.Name, azure.Params(*st.AzureParams))
+			if err != nil {
+				errs = multierror.Append(errs, err)
+			}
+
+		} else if st.GcsParams != nil {
+			storagesMap[st.Name], err = gcs.Init(st.Name, gcs.Params(*st.GcsParams))
+			if err != nil {
+				errs = multierror.Append(errs, err)
+			}
+
 		} else {
 			errs = multierror.Append(errs, fmt.Errorf("unable to define `%s` storage connect type by its params. Allowed connect params: %s", st.Name, strings.Join(allowedConnectParams, ", ")))
 		}
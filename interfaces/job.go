@@ -32,5 +32,6 @@ func (j Jobs) Close() error {
 
 type DumpObject struct {
 	TmpFile   string
+	MetaFiles []string // additional files delivered alongside the backup
 	Delivered bool
 }
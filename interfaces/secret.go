@@ -0,0 +1,8 @@
+package interfaces
+
+// SecretProvider resolves references to secrets used in configuration values,
+// e.g. `${vault:secret/db#password}`
+type SecretProvider interface {
+	GetName() string
+	GetSecret(ref string) (string, error)
+}
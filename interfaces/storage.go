@@ -1,6 +1,7 @@
 package interfaces
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"path"
@@ -16,6 +17,7 @@ type Storage interface {
 	IsLocal() int
 	SetBackupPath(path string)
 	SetRetention(r storage.Retention)
+	GetRetention() storage.Retention
 	DeliveryBackup(logCh chan logger.LogRecord, jobName, tmpBackupPath, ofs, bakType string) error
 	DeleteOldBackups(logCh chan logger.LogRecord, ofsPartsList []string, jobName, bakType string, full bool) error
 	GetFileReader(path string) (io.Reader, error)
@@ -36,9 +38,9 @@ func (s Storages) DeleteOldBackups(logCh chan logger.LogRecord, j Job, ofsPath s
 
 	for _, st := range s {
 		if ofsPath != "" {
-			err = st.DeleteOldBackups(logCh, []string{ofsPath}, j.GetName(), j.GetType(), true)
+			err = st.DeleteOldBackups(logCh, []string{ofsPath}, j.GetName(), getBakType(j), true)
 		} else {
-			err = st.DeleteOldBackups(logCh, j.GetTargetOfsList(), j.GetName(), j.GetType(), false)
+			err = st.DeleteOldBackups(logCh, j.GetTargetOfsList(), j.GetName(), getBakType(j), false)
 		}
 		if err != nil {
 			errs = multierror.Append(errs, err)
@@ -49,16 +51,27 @@ func (s Storages) DeleteOldBackups(logCh chan logger.LogRecord, j Job, ofsPath s
 
 func (s Storages) Delivery(logCh chan logger.LogRecord, job Job) error {
 
-	errs := new(multierror.Error)
+	var errs *multierror.Error
 
 	for ofs, dumpObj := range job.GetDumpObjects() {
 		if !dumpObj.Delivered {
+			// the dump is delivered if at least one storage has received the backup file
+			failed := 0
 			for _, st := range s {
-				if err := st.DeliveryBackup(logCh, job.GetName(), dumpObj.TmpFile, ofs, job.GetType()); err != nil {
+				if err := st.DeliveryBackup(logCh, job.GetName(), dumpObj.TmpFile, ofs, getBakType(job)); err != nil {
 					errs = multierror.Append(errs, err)
+					failed++
+					continue
+				}
+				// failures of files delivered alongside the backup don't affect the backup delivery state
+				for _, mtdFile := range dumpObj.MetaFiles {
+					if err := st.DeliveryBackup(logCh, job.GetName(), mtdFile, ofs, getBakType(job)); err != nil {
+						logCh <- logger.Log(job.GetName(), st.GetName()).Warnf("Failed to deliver metadata file '%s'. Error: %s", mtdFile, err)
+						errs = multierror.Append(errs, fmt.Errorf("metadata file `%s` isn't delivered to storage `%s`: %w", mtdFile, st.GetName(), err))
+					}
 				}
 			}
-			if errs.Len() < len(s) {
+			if failed < len(s) {
 				job.SetDumpObjectDelivered(ofs)
 			}
 		}
@@ -73,7 +86,7 @@ func (s Storages) CleanupTmpData(job Job) error {
 	for _, dumpObj := range job.GetDumpObjects() {
 
 		tmpBakFile := dumpObj.TmpFile
-		if job.GetType() == misc.IncBackupType {
+		if job.NeedToUpdateIncMeta() {
 			// cleanup tmp metadata files
 			_ = os.Remove(path.Join(tmpBakFile + ".inc"))
 			initFile := path.Join(tmpBakFile + ".init")
@@ -82,6 +95,11 @@ func (s Storages) CleanupTmpData(job Job) error {
 			}
 		}
 
+		// cleanup tmp files delivered alongside the backup
+		for _, mtdFile := range dumpObj.MetaFiles {
+			_ = os.Remove(mtdFile)
+		}
+
 		// cleanup tmp backup file
 		if err := os.Remove(tmpBakFile); err != nil {
 			errs = multierror.Append(errs, err)
@@ -90,6 +108,15 @@ func (s Storages) CleanupTmpData(job Job) error {
 	return errs.ErrorOrNil()
 }
 
+// getBakType returns the type of backups layout on storages.
+// Jobs that update incremental metadata use the same layout as `inc_files`
+func getBakType(j Job) string {
+	if j.NeedToUpdateIncMeta() {
+		return misc.IncBackupType
+	}
+	return j.GetType()
+}
+
 func (s Storages) Close() error {
 	for _, st := range s {
 		_ = st.Close()
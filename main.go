@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	appctx "github.com/nixys/nxs-go-appctx/v2"
 
 	"nxs-backup/ctx"
+	"nxs-backup/misc"
 	"nxs-backup/modules/arg_cmd"
 	"nxs-backup/modules/logger"
 	"nxs-backup/routines/logging"
@@ -20,9 +23,10 @@ import (
 func main() {
 
 	subCmds := ctx.SubCmds{
-		"start":    arg_cmd.Start,
-		"testCfg":  arg_cmd.TestConfig,
-		"generate": arg_cmd.GenerateConfig,
+		"start":              arg_cmd.Start,
+		"testCfg":            arg_cmd.TestConfig,
+		"generate":           arg_cmd.GenerateConfig,
+		"xtrabackup-restore": arg_cmd.XtrabackupRestore,
 	}
 
 	// Read command line arguments
@@ -45,6 +49,27 @@ func main() {
 
 	cc := appCtx.CustomCtx().(*ctx.Ctx)
 
+	// remove temp files with credentials and dumps on panic
+	defer misc.CleanupOnPanic()
+
+	// exit on termination signals, child processes are killed and the temp files are removed while freeing the context.
+	// The exit code is 128+signo as in shells, so the run interrupted by signal isn't treated as successful
+	var termSigNo atomic.Int32
+	termSigCh := make(chan os.Signal, 1)
+	signal.Notify(termSigCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		if s, ok := (<-termSigCh).(syscall.Signal); ok {
+			termSigNo.Store(int32(s))
+		}
+	}()
+	go func() {
+		ec := <-appCtx.ExitWait()
+		if s := termSigNo.Load(); s != 0 && ec == 0 {
+			ec = 128 + int(s)
+		}
+		os.Exit(ec)
+	}()
+
 	// Crate lockfile
 	lock, _ := lockfile.New(path.Join(os.TempDir(), "nxs-backup.lck"))
 	if cc.Cfg.WaitingTimeout != 0 {
@@ -73,6 +98,11 @@ func main() {
 	err = a.CmdHandler(appCtx)
 	// wait for logging and notification tasks complete
 	cc.WG.Wait()
+	// the run interrupted by signal is finished by the exit goroutine after the exit hooks are done
+	if termSigNo.Load() != 0 {
+		select {}
+	}
+	misc.CleanupTmpPaths()
 	if err != nil {
 		fmt.Println("exec error: ", err)
 		os.Exit(1)
@@ -0,0 +1,147 @@
+package misc
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// childKillTimeout is the time given to child processes to exit on SIGTERM before they are killed
+const childKillTimeout = 5 * time.Second
+
+// KillChildProcesses terminates all descendant processes (dump tools, tar, plugins, etc.) of the current process,
+// so they don't write into the temp paths removed on exit. Processes are found via `/proc`, so it works only on Linux
+func KillChildProcesses() {
+	pids := getDescendantPids(os.Getpid())
+	if len(pids) == 0 {
+		return
+	}
+
+	for _, pid := range pids {
+		_ = syscall.Kill(pid, syscall.SIGTERM)
+	}
+
+	deadline := time.Now().Add(childKillTimeout)
+	for time.Now().Before(deadline) {
+		alive := false
+		for _, pid := range pids {
+			if syscall.Kill(pid, 0) == nil {
+				alive = true
+				break
+			}
+		}
+		if !alive {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, pid := range pids {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+var exitHooks = struct {
+	sync.Mutex
+	hooks []*exitHook
+}{}
+
+type exitHook struct {
+	once sync.Once
+	fn   func()
+}
+
+// OnExit registers the function restoring the state changed by the job (resumes containers, starts replication,
+// unregisters temporary repositories, etc.). If the program is terminated by signal or panics, the registered
+// functions are called by RunExitHooks before the exit. The returned function calls the hook and unregisters it,
+// it should be deferred by the job. The hook is called only once, even if both ways run concurrently
+func OnExit(fn func()) func() {
+	h := &exitHook{fn: fn}
+
+	exitHooks.Lock()
+	exitHooks.hooks = append(exitHooks.hooks, h)
+	exitHooks.Unlock()
+
+	return func() {
+		h.once.Do(h.fn)
+
+		exitHooks.Lock()
+		defer exitHooks.Unlock()
+		for i, eh := range exitHooks.hooks {
+			if eh == h {
+				exitHooks.hooks = append(exitHooks.hooks[:i], exitHooks.hooks[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// RunExitHooks calls registered exit hooks in reverse order of the registration.
+// Child processes should be killed before, so the hooks don't restore the state under running dumps
+func RunExitHooks() {
+	exitHooks.Lock()
+	hooks := exitHooks.hooks
+	exitHooks.hooks = nil
+	exitHooks.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].once.Do(hooks[i].fn)
+	}
+}
+
+// CleanupOnPanic kills child processes, runs exit hooks and removes temp paths if the goroutine panics,
+// then the panic continues. It must be deferred directly at the beginning of each goroutine that runs dumps
+func CleanupOnPanic() {
+	if r := recover(); r != nil {
+		KillChildProcesses()
+		RunExitHooks()
+		CleanupTmpPaths()
+		panic(r)
+	}
+}
+
+// getDescendantPids returns pids of all processes in the tree of the parent process
+func getDescendantPids(parent int) (pids []int) {
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+
+	children := make(map[int][]int)
+	for _, stat := range stats {
+		data, err := os.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+		// the process name in parentheses may contain spaces, so fields are counted from its end
+		s := string(data)
+		i := strings.LastIndexByte(s, ')')
+		if i < 0 {
+			continue
+		}
+		fields := strings.Fields(s[i+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		if err != nil {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], pid)
+	}
+
+	queue := children[parent]
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		pids = append(pids, pid)
+		queue = append(queue, children[pid]...)
+	}
+
+	return
+}
@@ -0,0 +1,25 @@
+package misc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExitHooks(t *testing.T) {
+	var calls []string
+
+	runFirst := OnExit(func() { calls = append(calls, "first") })
+	runSecond := OnExit(func() { calls = append(calls, "second") })
+	OnExit(func() { calls = append(calls, "third") })
+
+	// the hook called by the job isn't called again on exit
+	runSecond()
+	RunExitHooks()
+	// the hook called on exit isn't called again by the job
+	runFirst()
+	RunExitHooks()
+
+	if want := []string{"second", "third", "first"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks calls = %v, want %v", calls, want)
+	}
+}
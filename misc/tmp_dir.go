@@ -0,0 +1,77 @@
+package misc
+
+import (
+	"os"
+	"sync"
+)
+
+var tmpPaths = struct {
+	sync.Mutex
+	privateDir string
+	tracked    map[string]struct{}
+}{
+	tracked: make(map[string]struct{}),
+}
+
+// GetPrivateTmpDir returns the private directory of the current run for credential files.
+// The directory is created on the first call with 0700 permissions and removed by CleanupTmpPaths
+func GetPrivateTmpDir() (string, error) {
+	tmpPaths.Lock()
+	defer tmpPaths.Unlock()
+
+	if tmpPaths.privateDir != "" {
+		return tmpPaths.privateDir, nil
+	}
+
+	dir, err := os.MkdirTemp("", "nxs-backup_")
+	if err != nil {
+		return "", err
+	}
+	tmpPaths.privateDir = dir
+
+	return dir, nil
+}
+
+// MkPrivateTmpDir creates the private (0700) directory with unique name inside the base dir for dump intermediates.
+// The directory is removed by CleanupTmpPaths if it isn't removed before by ReleaseTmpDir
+func MkPrivateTmpDir(baseDir, pattern string) (string, error) {
+	if err := os.MkdirAll(baseDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp(baseDir, pattern)
+	if err != nil {
+		return "", err
+	}
+
+	tmpPaths.Lock()
+	tmpPaths.tracked[dir] = struct{}{}
+	tmpPaths.Unlock()
+
+	return dir, nil
+}
+
+// ReleaseTmpDir removes the directory created by MkPrivateTmpDir with all its content
+func ReleaseTmpDir(dir string) {
+	_ = os.RemoveAll(dir)
+
+	tmpPaths.Lock()
+	delete(tmpPaths.tracked, dir)
+	tmpPaths.Unlock()
+}
+
+// CleanupTmpPaths removes the private directory and all tracked temp directories.
+// It must be called on program exit, including termination by signal or panic
+func CleanupTmpPaths() {
+	tmpPaths.Lock()
+	defer tmpPaths.Unlock()
+
+	if tmpPaths.privateDir != "" {
+		_ = os.RemoveAll(tmpPaths.privateDir)
+		tmpPaths.privateDir = ""
+	}
+	for dir := range tmpPaths.tracked {
+		_ = os.RemoveAll(dir)
+		delete(tmpPaths.tracked, dir)
+	}
+}
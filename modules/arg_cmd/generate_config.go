@@ -39,9 +39,21 @@ type sourceYaml struct {
 	Gzip               bool           `yaml:"gzip,omitempty"`
 	SaveAbsPath        bool           `yaml:"save_abs_path,omitempty"`
 	IsSlave            bool           `yaml:"is_slave,omitempty"`
+	SplitTables        *bool          `yaml:"split_tables,omitempty"`
 	ExtraKeys          string         `yaml:"db_extra_keys,omitempty"`
 	SkipBackupRotate   bool           `yaml:"skip_backup_rotate,omitempty"` // used by external
 	PrepareXtrabackup  bool           `yaml:"prepare_xtrabackup,omitempty"`
+	ClickhouseMethod   string         `yaml:"clickhouse_backup_method,omitempty"`
+	ClickhousePath     string         `yaml:"clickhouse_backup_path,omitempty"`
+	ESGlobalState      bool           `yaml:"es_include_global_state,omitempty"`
+	DockerVolumeLabels []string       `yaml:"docker_volume_labels,omitempty"`
+	DockerAction       string         `yaml:"docker_container_action,omitempty"`
+	DockerCntrLabels   []string       `yaml:"docker_container_labels,omitempty"`
+	DockerExportCntrs  []string       `yaml:"docker_export_containers,omitempty"`
+	GitSkipUnchanged   bool           `yaml:"git_skip_unchanged,omitempty"`
+	LdapMethod         string         `yaml:"ldap_method,omitempty"`
+	LdapSlapdConfig    string         `yaml:"ldap_slapd_config,omitempty"`
+	S3ChangedOnly      bool           `yaml:"s3_changed_only,omitempty"`
 }
 
 type srcConnectYaml struct {
@@ -54,6 +66,14 @@ type srcConnectYaml struct {
 	DBPassword     string        `yaml:"db_password,omitempty"`
 	MongoRSName    string        `yaml:"mongo_replica_set_name,omitempty"`
 	MongoRSAddr    string        `yaml:"mongo_replica_set_address,omitempty"`
+	EtcdEndpoints  []string      `yaml:"etcd_endpoints,omitempty"`
+	EtcdTLSCAFile  string        `yaml:"etcd_tls_ca_file,omitempty"`
+	EtcdTLSCert    string        `yaml:"etcd_tls_cert_file,omitempty"`
+	EtcdTLSKey     string        `yaml:"etcd_tls_key_file,omitempty"`
+	LdapURI        string        `yaml:"ldap_uri,omitempty"`
+	S3Endpoint     string        `yaml:"s3_endpoint,omitempty"`
+	S3AccessKeyID  string        `yaml:"s3_access_key_id,omitempty"`
+	S3SecretKey    string        `yaml:"s3_secret_access_key,omitempty"`
 	ConnectTimeout time.Duration `yaml:"connection_timeout,omitempty"`
 }
 
@@ -78,6 +98,9 @@ type storageConnect struct {
 	NfsParams    *nfsParams    `yaml:"nfs_params,omitempty"`
 	WebDavParams *webDavParams `yaml:"webdav_params,omitempty"`
 	SmbParams    *smbParams    `yaml:"smb_params,omitempty"`
+	ExecParams   *execParams   `yaml:"exec_params,omitempty"`
+	AzureParams  *azureParams  `yaml:"azure_params,omitempty"`
+	GcsParams    *gcsParams    `yaml:"gcs_params,omitempty"`
 }
 
 type s3Params struct {
@@ -127,6 +150,22 @@ type smbParams struct {
 	Share    string `yaml:"share"`
 }
 
+type azureParams struct {
+	AccountName string `yaml:"account_name"`
+	AccountKey  string `yaml:"account_key"`
+	Container   string `yaml:"container"`
+}
+
+type gcsParams struct {
+	BucketName      string `yaml:"bucket_name"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+type execParams struct {
+	Cmd  string   `yaml:"cmd"`
+	Args []string `yaml:"args,omitempty"`
+}
+
 func GenerateConfig(appCtx *appctx.AppContext) error {
 
 	var errs *multierror.Error
@@ -194,8 +233,9 @@ func GenerateConfig(appCtx *appctx.AppContext) error {
 					Socket:     "",
 					AuthFile:   "",
 				},
-				IsSlave:   false,
-				TargetDBs: []string{"all"},
+				IsSlave:     false,
+				SplitTables: new(bool),
+				TargetDBs:   []string{"all"},
 				Excludes: []string{
 					"mysql",
 					"information_schema",
@@ -309,6 +349,136 @@ func GenerateConfig(appCtx *appctx.AppContext) error {
 		job.StoragesOptions = genStorageOpts(params.Storages, false)
 		job.DumpCmd = "/path/to/backup_script.sh"
 		job.TmpDir = ""
+	case ctx.AllowedJobTypes[9]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name: "sqlite",
+				Gzip: true,
+				Targets: []string{
+					"/var/lib/app/*.db",
+				},
+				Excludes: []string{
+					"/var/lib/app/cache.db",
+				},
+			},
+		}
+	case ctx.AllowedJobTypes[10]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name: "etcd",
+				Gzip: true,
+				Connect: srcConnectYaml{
+					EtcdEndpoints: []string{"https://127.0.0.1:2379"},
+					EtcdTLSCAFile: "/etc/etcd/pki/ca.crt",
+					EtcdTLSCert:   "/etc/etcd/pki/client.crt",
+					EtcdTLSKey:    "/etc/etcd/pki/client.key",
+				},
+			},
+		}
+	case ctx.AllowedJobTypes[11]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name: "clickhouse",
+				Gzip: true,
+				Connect: srcConnectYaml{
+					DBHost:     "clickhouse",
+					DBPort:     "8123",
+					DBUser:     "default",
+					DBPassword: "defaultP@5s",
+				},
+				TargetDBs:        []string{"all"},
+				Excludes:         []string{"default"},
+				ClickhouseMethod: "auto",
+				ClickhousePath:   "/var/lib/clickhouse/backup",
+			},
+		}
+	case ctx.AllowedJobTypes[12]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name: "elasticsearch",
+				Gzip: true,
+				Connect: srcConnectYaml{
+					DBHost:     "elasticsearch",
+					DBPort:     "9200",
+					DBUser:     "elastic",
+					DBPassword: "elasticP@5s",
+				},
+				Targets:  []string{"*"},
+				Excludes: []string{".*"},
+			},
+		}
+	case ctx.AllowedJobTypes[13]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name: "docker",
+				Gzip: true,
+				Connect: srcConnectYaml{
+					Socket: "/var/run/docker.sock",
+				},
+				Targets:            []string{"all"},
+				Excludes:           []string{"*_cache"},
+				DockerVolumeLabels: []string{"backup=true"},
+				DockerAction:       "pause",
+				DockerCntrLabels:   []string{"backup.pause=true"},
+			},
+		}
+	case ctx.AllowedJobTypes[14]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name: "git",
+				Gzip: true,
+				Targets: []string{
+					"/srv/git/*.git",
+				},
+				Excludes: []string{
+					"/srv/git/archive_*.git",
+				},
+				GitSkipUnchanged: true,
+			},
+		}
+	case ctx.AllowedJobTypes[15]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name:            "slapcat",
+				Gzip:            true,
+				TargetDBs:       []string{"0", "dc=example,dc=com"},
+				LdapMethod:      "slapcat",
+				LdapSlapdConfig: "/etc/ldap/slapd.d",
+			},
+			{
+				Name: "ldapsearch",
+				Gzip: true,
+				Connect: srcConnectYaml{
+					LdapURI:    "ldaps://ldap.example.com",
+					DBUser:     "cn=admin,dc=example,dc=com",
+					DBPassword: "adminP@5s",
+				},
+				TargetDBs:  []string{"all"},
+				LdapMethod: "ldapsearch",
+			},
+		}
+	case ctx.AllowedJobTypes[16]:
+		job.StoragesOptions = genStorageOpts(params.Storages, false)
+		job.Sources = []sourceYaml{
+			{
+				Name: "uploads",
+				Gzip: true,
+				Connect: srcConnectYaml{
+					S3Endpoint:    "s3.amazonaws.com",
+					S3AccessKeyID: "my_s3_ak_id",
+					S3SecretKey:   "${env:S3_SECRET_KEY}",
+				},
+				Targets:  []string{"uploads-bucket/media"},
+				Excludes: []string{"*/tmp/*"},
+			},
+		}
 	default:
 		errs = multierror.Append(fmt.Errorf("Unknown job type. Allowed types: %s ", strings.Join(ctx.AllowedJobTypes, ", ")))
 	}
@@ -336,11 +506,17 @@ func GenerateConfig(appCtx *appctx.AppContext) error {
 	}
 	defer func() { _ = file.Close() }()
 
+	jobNode := yaml.Node{}
+	if err = jobNode.Encode(&job); err != nil {
+		return err
+	}
+	addKeyComments(&jobNode)
+
 	e := yaml.NewEncoder(file)
 	e.SetIndent(2)
 	defer func() { _ = e.Close() }()
 
-	if err = e.Encode(&job); err != nil {
+	if err = e.Encode(&jobNode); err != nil {
 		return err
 	}
 
@@ -378,6 +554,25 @@ func genStorageOpts(storages map[string]string, incBackup bool) (sts []storageOp
 	return
 }
 
+// keyComments are the comments added to the options of the generated config which need attention
+var keyComments = map[string]string{
+	"split_tables": "tables are dumped by separate mysqldump runs and aren't consistent with each other",
+}
+
+// addKeyComments adds comments from keyComments to the mapping keys of the node and its children
+func addKeyComments(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if c, ok := keyComments[node.Content[i].Value]; ok {
+				node.Content[i].LineComment = c
+			}
+		}
+	}
+	for _, n := range node.Content {
+		addKeyComments(n)
+	}
+}
+
 func updateStorageConnects(cfgPath string, storages map[string]string) error {
 	content, err := ioutil.ReadFile(cfgPath)
 	if err != nil {
@@ -449,6 +644,9 @@ func getStorageConnects(storages map[string]string) ([]*yaml.Node, error) {
 		"smb",
 		"nfs",
 		"webdav",
+		"exec",
+		"azure",
+		"gcs",
 	}
 	var sts []*yaml.Node
 
@@ -509,6 +707,22 @@ func getStorageConnects(storages map[string]string) ([]*yaml.Node, error) {
 				Password:   "my_webdav_pass",
 				OAuthToken: "my_webdav_oauth_token",
 			}
+		case allowedStorageTypes[7]:
+			st.ExecParams = &execParams{
+				Cmd:  "/path/to/storage_plugin",
+				Args: []string{"--config", "/path/to/plugin.conf"},
+			}
+		case allowedStorageTypes[8]:
+			st.AzureParams = &azureParams{
+				AccountName: "my_account_name",
+				AccountKey:  "my_account_key",
+				Container:   "my_container",
+			}
+		case allowedStorageTypes[9]:
+			st.GcsParams = &gcsParams{
+				BucketName:      "my_bucket",
+				CredentialsFile: "/path/to/service_account.json",
+			}
 		default:
 			return nil, fmt.Errorf("Unknown strage type. Supported types: %s ", strings.Join(allowedStorageTypes, ", "))
 		}
@@ -0,0 +1,24 @@
+package arg_cmd
+
+import (
+	"fmt"
+
+	appctx "github.com/nixys/nxs-go-appctx/v2"
+
+	"nxs-backup/ctx"
+	"nxs-backup/modules/backup/mysql_xtrabackup"
+)
+
+func XtrabackupRestore(appCtx *appctx.AppContext) error {
+
+	cc := appCtx.CustomCtx().(*ctx.Ctx)
+	params := cc.CmdParams.(*ctx.XtrabackupRestoreCmd)
+
+	if err := mysql_xtrabackup.Restore(params.TargetDir, params.Backups, params.Binary, params.EncKey); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully restored backup to: %s\n", params.TargetDir)
+
+	return nil
+}
@@ -2,9 +2,6 @@ package backup
 
 import (
 	"fmt"
-	"os"
-	"path"
-	"path/filepath"
 
 	"github.com/hashicorp/go-multierror"
 
@@ -43,13 +40,15 @@ func Perform(logCh chan logger.LogRecord, job interfaces.Job) error {
 	logCh <- logger.Log(job.GetName(), "").Info("Starting")
 
 	if jobTmpDir := job.GetTempDir(); jobTmpDir != "" {
-		tmpDirPath = path.Join(jobTmpDir, fmt.Sprintf("%s_%s", job.GetType(), misc.GetDateTimeNow("")))
-		err := os.MkdirAll(tmpDirPath, os.ModePerm)
+		// private dir is accessible only by the running user and is removed even if the job panics
+		var err error
+		tmpDirPath, err = misc.MkPrivateTmpDir(jobTmpDir, fmt.Sprintf("%s_%s_", job.GetType(), misc.GetDateTimeNow("")))
 		if err != nil {
 			logCh <- logger.Log(job.GetName(), "").Errorf("Job `%s` failed. Unable to create tmp dir with next error: %s", job.GetName(), err)
 			errs = multierror.Append(errs, err)
 			return errs.ErrorOrNil()
 		}
+		defer misc.ReleaseTmpDir(tmpDirPath)
 	}
 
 	if err := job.DoBackup(logCh, tmpDirPath); err != nil {
@@ -57,20 +56,6 @@ func Perform(logCh chan logger.LogRecord, job interfaces.Job) error {
 	}
 
 	_ = job.CleanupTmpData()
-	_ = filepath.Walk(tmpDirPath,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			// try to delete empty dirs
-			if info.IsDir() {
-				_ = os.Remove(path)
-			}
-			return nil
-		})
-	// cleanup tmp dir
-	_ = os.Remove(tmpDirPath)
 
 	logCh <- logger.Log(job.GetName(), "").Info("Finished")
 
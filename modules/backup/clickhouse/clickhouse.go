@@ -0,0 +1,367 @@
+package clickhouse
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/exec_cmd"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/connectors/clickhouse_connect"
+	"nxs-backup/modules/logger"
+)
+
+const (
+	methodAuto             = "auto"
+	methodNative           = "native"
+	methodClickhouseBackup = "clickhouse-backup"
+)
+
+// system databases are never backed up
+var systemDatabases = []string{"system", "information_schema", "INFORMATION_SCHEMA"}
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	connect      *clickhouse_connect.Conn
+	connParams   clickhouse_connect.Params
+	dbName       string
+	ignoreTables []string
+	method       string
+	backupPath   string
+	gzip         bool
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type SourceParams struct {
+	Name          string
+	ConnectParams clickhouse_connect.Params
+	TargetDBs     []string
+	Excludes      []string
+	Gzip          bool
+	Method        string // `auto`, `native` or `clickhouse-backup`
+	BackupPath    string // Directory where the server or `clickhouse-backup` creates local backups
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	// check if tar available
+	if _, err := exec_cmd.Exec("tar", "--version"); err != nil {
+		return nil, fmt.Errorf("Job `%s` init failed. Can't check `tar` version. Please install `tar`. Error: %s ", jp.Name, err)
+	}
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for _, src := range jp.Sources {
+
+		method := src.Method
+		switch method {
+		case "", methodAuto:
+			method = methodNative
+			if _, err := exec.LookPath("clickhouse-backup"); err == nil {
+				method = methodClickhouseBackup
+			}
+		case methodNative:
+		case methodClickhouseBackup:
+			if _, err := exec_cmd.Exec("clickhouse-backup", "--version"); err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Can't check `clickhouse-backup` version. Please install `clickhouse-backup`. Error: %s ", jp.Name, err)
+			}
+		default:
+			return nil, fmt.Errorf("Job `%s` init failed. Unknown backup method `%s` of source `%s`. Allowed methods: auto, native, clickhouse-backup ", jp.Name, src.Method, src.Name)
+		}
+
+		conn, err := clickhouse_connect.GetConnect(src.ConnectParams)
+		if err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. ClickHouse connect error: %s ", jp.Name, err)
+		}
+
+		// fetch all databases
+		var databases []string
+		if misc.Contains(src.TargetDBs, "all") {
+			databases, err = conn.QueryList("SHOW DATABASES")
+			if err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to list databases. Error: %s ", jp.Name, err)
+			}
+		} else {
+			databases = src.TargetDBs
+		}
+
+		for _, db := range databases {
+			if misc.Contains(src.Excludes, db) || misc.Contains(systemDatabases, db) {
+				continue
+			}
+
+			var ignoreTables []string
+			for _, excl := range src.Excludes {
+				if strings.HasPrefix(excl, db+".") {
+					ignoreTables = append(ignoreTables, excl)
+				}
+			}
+			j.targets[src.Name+"/"+db] = target{
+				connect:      conn,
+				connParams:   src.ConnectParams,
+				dbName:       db,
+				ignoreTables: ignoreTables,
+				method:       method,
+				backupPath:   src.BackupPath,
+				gzip:         src.Gzip,
+			}
+		}
+	}
+
+	return j, nil
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "clickhouse"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return false
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofsPart, tgt := range j.targets {
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "tar", "", tgt.gzip)
+		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if err = j.createTmpBackup(logCh, tmpBackupFile, tgt); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+		j.dumpedObjects[ofsPart] = interfaces.DumpObject{TmpFile: tmpBackupFile}
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string, tgt target) error {
+
+	backupName := "nxs-backup_" + tgt.dbName + "_" + misc.GetDateTimeNow("")
+	backupDir := path.Join(tgt.backupPath, backupName)
+
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` backup", tgt.dbName)
+
+	var err error
+	if tgt.method == methodClickhouseBackup {
+		err = j.toolBackup(logCh, backupName, tgt)
+		defer j.toolDelete(logCh, backupName, tgt)
+	} else {
+		err = j.nativeBackup(logCh, backupDir, tgt)
+		defer func() { _ = os.RemoveAll(backupDir) }()
+	}
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to backup `%s`. Error: %s", tgt.dbName, err)
+		return err
+	}
+
+	if err = targz.Tar(backupDir, tmpBackupFile, false, tgt.gzip, false, nil); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
+		if serr, ok := err.(targz.Error); ok {
+			logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", serr.Stderr)
+		}
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Backup of `%s` completed", tgt.dbName)
+
+	return nil
+}
+
+// nativeBackup makes backup with `BACKUP DATABASE` query. The backup is written by the server,
+// so the path must be allowed in `backups.allowed_path` server setting
+func (j *job) nativeBackup(logCh chan logger.LogRecord, backupDir string, tgt target) error {
+
+	query := "BACKUP DATABASE " + clickhouse_connect.QuoteIdentifier(tgt.dbName)
+	if len(tgt.ignoreTables) > 0 {
+		var tables []string
+		for _, t := range tgt.ignoreTables {
+			tables = append(tables, clickhouse_connect.QuoteIdentifier(tgt.dbName)+"."+clickhouse_connect.QuoteIdentifier(strings.TrimPrefix(t, tgt.dbName+".")))
+		}
+		query += " EXCEPT TABLES " + strings.Join(tables, ", ")
+	}
+	query += " TO File(" + clickhouse_connect.QuoteString(backupDir+"/") + ")"
+
+	logCh <- logger.Log(j.name, "").Debugf("Backup query: %s", query)
+
+	res, err := tgt.connect.Query(query)
+	if err != nil {
+		return err
+	}
+	logCh <- logger.Log(j.name, "").Debugf("Backup status: %s", strings.TrimSpace(res))
+
+	// the backup is written to the file system of the server, so it must be the same host
+	if _, err = os.ReadDir(backupDir); err != nil {
+		return fmt.Errorf("backup created by the server isn't readable at `%s`, `clickhouse_backup_path` must be on the host where nxs-backup is running: %w", backupDir, err)
+	}
+
+	return nil
+}
+
+// toolBackup makes backup with `clickhouse-backup` tool, connection params of the source override ones from its config
+func (j *job) toolBackup(logCh chan logger.LogRecord, backupName string, tgt target) error {
+
+	args := []string{"create", "--tables=" + tgt.dbName + ".*", backupName}
+
+	cmd := toolCmd(tgt, args...)
+	if len(tgt.ignoreTables) > 0 {
+		cmd.Env = append(cmd.Env, "CLICKHOUSE_SKIP_TABLES="+strings.Join(append([]string{"system.*", "INFORMATION_SCHEMA.*", "information_schema.*"}, tgt.ignoreTables...), ","))
+	}
+
+	return j.execTool(logCh, cmd)
+}
+
+func (j *job) toolDelete(logCh chan logger.LogRecord, backupName string, tgt target) {
+	cmd := toolCmd(tgt, "delete", "local", backupName)
+	if err := j.execTool(logCh, cmd); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to delete local backup `%s`. Error: %s", backupName, err)
+	}
+}
+
+// toolCmd returns `clickhouse-backup` command with connection params of the source passed via environment
+func toolCmd(tgt target, args ...string) *exec.Cmd {
+	// clickhouse-backup connects via the native protocol, so the HTTP interface port isn't suitable for it
+	nativePort := tgt.connParams.TCPPort
+	if nativePort == "" {
+		nativePort = "9000"
+		if tgt.connParams.Secure {
+			nativePort = "9440"
+		}
+	}
+
+	cmd := exec.Command("clickhouse-backup", args...)
+	cmd.Env = append(os.Environ(),
+		"CLICKHOUSE_HOST="+tgt.connParams.Host,
+		"CLICKHOUSE_PORT="+nativePort,
+		"CLICKHOUSE_USERNAME="+tgt.connParams.User,
+		"CLICKHOUSE_PASSWORD="+tgt.connParams.Passwd,
+		"CLICKHOUSE_SECURE="+strconv.FormatBool(tgt.connParams.Secure),
+		"CLICKHOUSE_SKIP_VERIFY="+strconv.FormatBool(tgt.connParams.Insecure),
+	)
+
+	return cmd
+}
+
+func (j *job) execTool(logCh chan logger.LogRecord, cmd *exec.Cmd) error {
+	var stderr, stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s", err, stderr.String())
+	}
+	logCh <- logger.Log(j.name, "").Debugf("STDOUT: %s", stdout.String())
+
+	return nil
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
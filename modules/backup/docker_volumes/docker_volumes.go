@@ -0,0 +1,374 @@
+package docker_volumes
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mb0/glob"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/exec_cmd"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/connectors/docker_connect"
+	"nxs-backup/modules/logger"
+)
+
+const (
+	actionNone  = "none"
+	actionPause = "pause"
+	actionStop  = "stop"
+)
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	connect         *docker_connect.Conn
+	volume          string
+	mountpoint      string
+	container       string
+	containerAction string
+	containerLabels []string
+	gzip            bool
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type SourceParams struct {
+	Name             string
+	ConnectParams    docker_connect.Params
+	Targets          []string // Volume names or glob patterns
+	Excludes         []string // Volume names or glob patterns to be excluded
+	VolumeLabels     []string // Label filters of volumes, `key` or `key=value`
+	ContainerAction  string   // Action on containers using the volume during the archive: `none`, `pause` or `stop`
+	ContainerLabels  []string // Label filters of containers the action is applied to
+	ExportContainers []string // Names of containers which filesystems are exported
+	Gzip             bool
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	// check if tar available
+	if _, err := exec_cmd.Exec("tar", "--version"); err != nil {
+		return nil, fmt.Errorf("Job `%s` init failed. Can't check `tar` version. Please install `tar`. Error: %s ", jp.Name, err)
+	}
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for _, src := range jp.Sources {
+
+		switch src.ContainerAction {
+		case "", actionNone, actionPause, actionStop:
+		default:
+			return nil, fmt.Errorf("Job `%s` init failed. Unknown container action `%s` of source `%s`. Allowed actions: none, pause, stop ", jp.Name, src.ContainerAction, src.Name)
+		}
+
+		conn, err := docker_connect.GetConnect(src.ConnectParams)
+		if err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Docker connect error: %s ", jp.Name, err)
+		}
+
+		var filters map[string][]string
+		if len(src.VolumeLabels) > 0 {
+			filters = map[string][]string{"label": src.VolumeLabels}
+		}
+		volumes, err := conn.ListVolumes(filters)
+		if err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Unable to list volumes. Error: %s ", jp.Name, err)
+		}
+
+		for _, vol := range volumes {
+			match, err := matchAny(src.Targets, vol.Name, true)
+			if err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to process target pattern. Error: %s ", jp.Name, err)
+			}
+			if !match {
+				continue
+			}
+			excluded, err := matchAny(src.Excludes, vol.Name, false)
+			if err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to process exclude pattern. Error: %s ", jp.Name, err)
+			}
+			if excluded {
+				continue
+			}
+
+			j.targets[path.Join(src.Name, "volumes", vol.Name)] = target{
+				connect:         conn,
+				volume:          vol.Name,
+				mountpoint:      vol.Mountpoint,
+				containerAction: src.ContainerAction,
+				containerLabels: src.ContainerLabels,
+				gzip:            src.Gzip,
+			}
+		}
+
+		for _, c := range src.ExportContainers {
+			j.targets[path.Join(src.Name, "containers", c)] = target{
+				connect:   conn,
+				container: c,
+				gzip:      src.Gzip,
+			}
+		}
+	}
+
+	return j, nil
+}
+
+// matchAny checks if the name matches any of glob patterns. Empty list or `all` keyword matches if matchEmpty is set
+func matchAny(patterns []string, name string, matchEmpty bool) (bool, error) {
+	if len(patterns) == 0 || misc.Contains(patterns, "all") {
+		return matchEmpty, nil
+	}
+	for _, p := range patterns {
+		match, err := glob.Match(p, name)
+		if err != nil {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "docker_volumes"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return false
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofsPart, tgt := range j.targets {
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "tar", "", tgt.gzip)
+		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if tgt.container != "" {
+			err = j.exportContainer(logCh, tmpBackupFile, tgt)
+		} else {
+			err = j.archiveVolume(logCh, tmpBackupFile, tgt)
+		}
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+		j.dumpedObjects[ofsPart] = interfaces.DumpObject{TmpFile: tmpBackupFile}
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// archiveVolume packs the volume data directory into tar. Running containers using the volume
+// are paused or stopped during the archive if it is required
+func (j *job) archiveVolume(logCh chan logger.LogRecord, tmpBackupFile string, tgt target) error {
+
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` volume backup", tgt.volume)
+
+	if tgt.containerAction == actionPause || tgt.containerAction == actionStop {
+		resume, err := j.suspendContainers(logCh, tgt)
+		defer resume()
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to %s containers using volume `%s`. Error: %s", tgt.containerAction, tgt.volume, err)
+			return err
+		}
+	}
+
+	if err := targz.Tar(tgt.mountpoint, tmpBackupFile, false, tgt.gzip, false, nil); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
+		if serr, ok := err.(targz.Error); ok {
+			logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", serr.Stderr)
+		}
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Backup of `%s` volume completed", tgt.volume)
+
+	return nil
+}
+
+// suspendContainers pauses or stops running containers using the volume. The returned function resumes them,
+// it's also called if the program is terminated during the archive
+func (j *job) suspendContainers(logCh chan logger.LogRecord, tgt target) (func(), error) {
+	var (
+		mu        sync.Mutex
+		suspended []docker_connect.Container
+	)
+
+	resumeAction := "unpause"
+	if tgt.containerAction == actionStop {
+		resumeAction = "start"
+	}
+	resume := misc.OnExit(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for i := len(suspended) - 1; i >= 0; i-- {
+			c := suspended[i]
+			if err := tgt.connect.ContainerAction(c.ID, resumeAction); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Unable to %s container `%s`. Error: %s", resumeAction, containerName(c), err)
+				continue
+			}
+			logCh <- logger.Log(j.name, "").Infof("Container `%s` resumed (%s)", containerName(c), resumeAction)
+		}
+		suspended = nil
+	})
+
+	filters := map[string][]string{"volume": {tgt.volume}, "status": {"running"}}
+	if len(tgt.containerLabels) > 0 {
+		filters["label"] = tgt.containerLabels
+	}
+	containers, err := tgt.connect.ListContainers(filters)
+	if err != nil {
+		return resume, err
+	}
+
+	for _, c := range containers {
+		mu.Lock()
+		err = tgt.connect.ContainerAction(c.ID, tgt.containerAction)
+		if err == nil {
+			suspended = append(suspended, c)
+		}
+		mu.Unlock()
+		if err != nil {
+			return resume, err
+		}
+		logCh <- logger.Log(j.name, "").Infof("Container `%s` suspended (%s)", containerName(c), tgt.containerAction)
+	}
+
+	return resume, nil
+}
+
+// exportContainer writes the tar archive of the container filesystem. Volumes mounted into the container aren't included
+func (j *job) exportContainer(logCh chan logger.LogRecord, tmpBackupFile string, tgt target) error {
+
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` container filesystem export", tgt.container)
+
+	w, err := targz.GetFileWriter(tmpBackupFile, tgt.gzip)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp file. Error: %s", err)
+		return err
+	}
+	defer func() { _ = w.Close() }()
+
+	if err = tgt.connect.ExportContainer(tgt.container, w); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to export container `%s`. Error: %s", tgt.container, err)
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Export of `%s` container completed", tgt.container)
+
+	return nil
+}
+
+func containerName(c docker_connect.Container) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
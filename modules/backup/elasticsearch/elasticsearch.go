@@ -0,0 +1,445 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/exec_cmd"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/connectors/elastic_connect"
+	"nxs-backup/modules/logger"
+)
+
+// interval of the snapshot status polling
+const pollInterval = 10 * time.Second
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	connect            *elastic_connect.Conn
+	indices            []string
+	includeGlobalState bool
+	repoOwner          *repoOwner
+	snapshotTimeout    time.Duration
+	gzip               bool
+}
+
+// repoOwner is the user of the server the snapshot repository dir is owned by
+type repoOwner struct {
+	uid int
+	gid int
+}
+
+type snapshotStatus struct {
+	Snapshots []struct {
+		State       string `json:"state"`
+		ShardsStats struct {
+			Initializing int `json:"initializing"`
+			Started      int `json:"started"`
+			Finalizing   int `json:"finalizing"`
+			Done         int `json:"done"`
+			Failed       int `json:"failed"`
+			Total        int `json:"total"`
+		} `json:"shards_stats"`
+	} `json:"snapshots"`
+}
+
+type snapshotInfo struct {
+	Snapshots []struct {
+		State    string `json:"state"`
+		Failures []struct {
+			Index   string `json:"index"`
+			ShardID int    `json:"shard_id"`
+			NodeID  string `json:"node_id"`
+			Reason  string `json:"reason"`
+			Status  string `json:"status"`
+		} `json:"failures"`
+		Shards struct {
+			Total      int `json:"total"`
+			Failed     int `json:"failed"`
+			Successful int `json:"successful"`
+		} `json:"shards"`
+	} `json:"snapshots"`
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type SourceParams struct {
+	Name               string
+	ConnectParams      elastic_connect.Params
+	Targets            []string // Index names or wildcard patterns
+	Excludes           []string // Index names or wildcard patterns to be excluded
+	IncludeGlobalState bool
+	RepoOwner          string        // User name or `uid[:gid]` of the server user, empty if the server runs as the same user
+	SnapshotTimeout    time.Duration // Maximum time of the snapshot in seconds, 0 disables the limit
+	Gzip               bool
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	// check if tar available
+	if _, err := exec_cmd.Exec("tar", "--version"); err != nil {
+		return nil, fmt.Errorf("Job `%s` init failed. Can't check `tar` version. Please install `tar`. Error: %s ", jp.Name, err)
+	}
+
+	if jp.TmpDir == "" {
+		return nil, fmt.Errorf("Job `%s` init failed. The `tmp_dir` option is required to place the snapshot repository ", jp.Name)
+	}
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for _, src := range jp.Sources {
+
+		conn, _, err := elastic_connect.GetConnect(src.ConnectParams)
+		if err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Elasticsearch connect error: %s ", jp.Name, err)
+		}
+
+		indices := src.Targets
+		if len(indices) == 0 || misc.Contains(indices, "all") {
+			indices = []string{"*"}
+		}
+		// excluded indices are prefixed with `-` in multi-target syntax
+		for _, excl := range src.Excludes {
+			indices = append(indices, "-"+excl)
+		}
+
+		var owner *repoOwner
+		if src.RepoOwner != "" {
+			if owner, err = getRepoOwner(src.RepoOwner); err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to find snapshot repository owner `%s` of source `%s`. Error: %s ", jp.Name, src.RepoOwner, src.Name, err)
+			}
+		}
+
+		j.targets[src.Name] = target{
+			connect:            conn,
+			indices:            indices,
+			includeGlobalState: src.IncludeGlobalState,
+			repoOwner:          owner,
+			snapshotTimeout:    src.SnapshotTimeout * time.Second,
+			gzip:               src.Gzip,
+		}
+	}
+
+	return j, nil
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "elasticsearch"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return false
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofsPart, tgt := range j.targets {
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "tar", "", tgt.gzip)
+		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if err = j.createTmpBackup(logCh, tmpBackupFile, ofsPart, tgt); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+		j.dumpedObjects[ofsPart] = interfaces.DumpObject{TmpFile: tmpBackupFile}
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// createTmpBackup registers the temporary fs repository, makes the snapshot into it and packs the repository into tar
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtName string, tgt target) error {
+
+	// repository is written by the server, so it is placed in the job tmp dir (must be listed in `path.repo`)
+	// and is owned by the server user. The group can read it, other users have no access
+	repoDir, err := misc.MkPrivateTmpDir(j.tmpDir, "elasticsearch_repo_")
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create snapshot repository dir: %s", err)
+		return err
+	}
+	defer misc.ReleaseTmpDir(repoDir)
+	if tgt.repoOwner != nil {
+		if err = os.Chown(repoDir, tgt.repoOwner.uid, tgt.repoOwner.gid); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to change owner of snapshot repository dir: %s", err)
+			return err
+		}
+	}
+	if err = os.Chmod(repoDir, 0750); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to set permissions of snapshot repository dir: %s", err)
+		return err
+	}
+
+	repoName := path.Base(repoDir)
+	snapName := snapshotName(tgtName)
+	repoPath := "/_snapshot/" + url.PathEscape(repoName)
+	snapPath := repoPath + "/" + url.PathEscape(snapName)
+
+	logCh <- logger.Log(j.name, "").Debugf("Registering snapshot repository `%s` at %s", repoName, repoDir)
+	err = tgt.connect.Do(http.MethodPut, repoPath, map[string]interface{}{
+		"type":     "fs",
+		"settings": map[string]interface{}{"location": repoDir},
+	}, nil)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to register snapshot repository: %s", err)
+		return err
+	}
+	// the running snapshot is aborted and the repository is unregistered even if the program is terminated,
+	// the snapshot is deleted in any case, since the repository dir is removed after the archive
+	defer misc.OnExit(func() {
+		_ = tgt.connect.Do(http.MethodDelete, snapPath, nil, nil)
+		// unregistering doesn't delete the repository content
+		if err := tgt.connect.Do(http.MethodDelete, repoPath, nil, nil); err != nil {
+			logCh <- logger.Log(j.name, "").Warnf("Unable to unregister snapshot repository `%s`: %s", repoName, err)
+		}
+	})()
+
+	logCh <- logger.Log(j.name, "").Infof("Starting snapshot `%s` of indices: %s", snapName, strings.Join(tgt.indices, ","))
+	err = tgt.connect.Do(http.MethodPut, snapPath+"?wait_for_completion=false", map[string]interface{}{
+		"indices":              strings.Join(tgt.indices, ","),
+		"ignore_unavailable":   true,
+		"include_global_state": tgt.includeGlobalState,
+	}, nil)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to start snapshot: %s", err)
+		return err
+	}
+
+	if err = j.waitSnapshot(logCh, tgt.connect, snapPath, tgt.snapshotTimeout); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to get snapshot status: %s", err)
+		// deletion of the running snapshot aborts it
+		if err := tgt.connect.Do(http.MethodDelete, snapPath, nil, nil); err != nil {
+			logCh <- logger.Log(j.name, "").Warnf("Unable to abort snapshot `%s`: %s", snapName, err)
+		}
+		return err
+	}
+
+	if err = j.checkSnapshot(logCh, tgt.connect, snapPath, snapName); err != nil {
+		return err
+	}
+
+	if err = targz.Tar(repoDir, tmpBackupFile, false, tgt.gzip, false, nil); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
+		if serr, ok := err.(targz.Error); ok {
+			logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", serr.Stderr)
+		}
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Snapshot `%s` completed", snapName)
+
+	return nil
+}
+
+// waitSnapshot polls the snapshot status and logs its progress until the snapshot is finished or the timeout expires
+func (j *job) waitSnapshot(logCh chan logger.LogRecord, conn *elastic_connect.Conn, snapPath string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var status snapshotStatus
+		if err := conn.Do(http.MethodGet, snapPath+"/_status", nil, &status); err != nil {
+			return err
+		}
+		if len(status.Snapshots) == 0 {
+			return fmt.Errorf("snapshot not found")
+		}
+
+		s := status.Snapshots[0]
+		switch s.State {
+		case "IN_PROGRESS", "STARTED", "INIT":
+			logCh <- logger.Log(j.name, "").Infof("Snapshot in progress: %d of %d shards done, %d failed",
+				s.ShardsStats.Done, s.ShardsStats.Total, s.ShardsStats.Failed)
+			if timeout > 0 && time.Now().After(deadline) {
+				return fmt.Errorf("snapshot isn't finished in %s", timeout)
+			}
+			time.Sleep(pollInterval)
+		default:
+			return nil
+		}
+	}
+}
+
+// checkSnapshot reports failed shards of the finished snapshot. Partial snapshot is delivered with warnings
+func (j *job) checkSnapshot(logCh chan logger.LogRecord, conn *elastic_connect.Conn, snapPath, snapName string) error {
+	var info snapshotInfo
+	if err := conn.Do(http.MethodGet, snapPath, nil, &info); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to get snapshot info: %s", err)
+		return err
+	}
+	if len(info.Snapshots) == 0 {
+		err := fmt.Errorf("snapshot `%s` not found", snapName)
+		logCh <- logger.Log(j.name, "").Error(err)
+		return err
+	}
+
+	s := info.Snapshots[0]
+	for _, f := range s.Failures {
+		msg := fmt.Sprintf("Snapshot `%s` failed for shard %d of index `%s` on node `%s`: %s %s", snapName, f.ShardID, f.Index, f.NodeID, f.Status, f.Reason)
+		if s.State == "SUCCESS" || s.State == "PARTIAL" {
+			logCh <- logger.Log(j.name, "").Warn(msg)
+		} else {
+			logCh <- logger.Log(j.name, "").Error(msg)
+		}
+	}
+
+	switch s.State {
+	case "SUCCESS":
+		logCh <- logger.Log(j.name, "").Infof("Snapshot `%s` finished: %d of %d shards successful", snapName, s.Shards.Successful, s.Shards.Total)
+	case "PARTIAL":
+		logCh <- logger.Log(j.name, "").Warnf("Snapshot `%s` is partial: %d of %d shards failed", snapName, s.Shards.Failed, s.Shards.Total)
+	default:
+		err := fmt.Errorf("snapshot `%s` finished with state %s", snapName, s.State)
+		logCh <- logger.Log(j.name, "").Error(err)
+		return err
+	}
+
+	return nil
+}
+
+// getRepoOwner returns uid and gid of the user defined by name, uid or `uid:gid`.
+// Numeric ids are used as is, so the server may run in a container with users unknown to the host
+func getRepoOwner(owner string) (*repoOwner, error) {
+	uidStr, gidStr, hasGid := strings.Cut(owner, ":")
+	if uid, err := strconv.Atoi(uidStr); err == nil {
+		gid := uid
+		if hasGid {
+			if gid, err = strconv.Atoi(gidStr); err != nil {
+				return nil, fmt.Errorf("wrong gid `%s`", gidStr)
+			}
+		} else if u, err := user.LookupId(uidStr); err == nil {
+			gid, _ = strconv.Atoi(u.Gid)
+		}
+		return &repoOwner{uid: uid, gid: gid}, nil
+	}
+
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return nil, err
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+
+	return &repoOwner{uid: uid, gid: gid}, nil
+}
+
+// snapshotName returns the snapshot name based on the source name, that may contain only lowercase letters, digits, `-` and `_`
+func snapshotName(srcName string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(srcName))
+
+	return name + "_" + misc.GetDateTimeNow("")
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
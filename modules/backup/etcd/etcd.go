@@ -0,0 +1,376 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	bolt "go.etcd.io/bbolt"
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/connectors/etcd_connect"
+	"nxs-backup/modules/logger"
+)
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	connParams etcd_connect.Params
+	gzip       bool
+}
+
+// snapshotMetadata contains the snapshot status saved alongside the snapshot.
+// Revision, hash and total keys are read from the snapshot file the same way as `etcdutl snapshot status` does
+type snapshotMetadata struct {
+	Endpoint  string `json:"endpoint"`
+	ClusterID string `json:"cluster_id"`
+	MemberID  string `json:"member_id"`
+	Version   string `json:"version"`
+	Revision  int64  `json:"revision"`
+	TotalKey  int    `json:"total_key"`
+	Hash      uint32 `json:"hash"`
+	DBSize    int64  `json:"db_size"`
+	SHA256    string `json:"sha256"`
+	CreatedAt string `json:"created_at"`
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type SourceParams struct {
+	Name          string
+	ConnectParams etcd_connect.Params
+	Gzip          bool
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for _, src := range jp.Sources {
+
+		cli, _, err := etcd_connect.GetConnect(src.ConnectParams)
+		if err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Etcd connect error: %s ", jp.Name, err)
+		}
+		_ = cli.Close()
+
+		j.targets[src.Name] = target{
+			connParams: src.ConnectParams,
+			gzip:       src.Gzip,
+		}
+	}
+
+	return j, nil
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "etcd"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return false
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofsPart, tgt := range j.targets {
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "db", "", tgt.gzip)
+		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if err = j.createTmpBackup(logCh, tmpBackupFile, ofsPart, tgt); err != nil {
+			logCh <- logger.Log(j.name, "").Error("Failed to create temp backup.")
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtName string, tgt target) error {
+
+	tmpSnapshot := strings.TrimSuffix(tmpBackupFile, ".gz")
+
+	cli, endpoint, err := etcd_connect.GetConnect(tgt.connParams)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to connect to `%s`. Error: %s", tgtName, err)
+		return err
+	}
+	defer func() { _ = cli.Close() }()
+
+	ctx := context.Background()
+
+	status, err := cli.Status(ctx, endpoint)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to get status of `%s`. Error: %s", endpoint, err)
+		return err
+	}
+	mtd := snapshotMetadata{
+		Endpoint:  endpoint,
+		ClusterID: fmt.Sprintf("%x", status.Header.ClusterId),
+		MemberID:  fmt.Sprintf("%x", status.Header.MemberId),
+		Version:   status.Version,
+		CreatedAt: misc.GetDateTimeNow(""),
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Starting to snapshot `%s` source from `%s`", tgtName, endpoint)
+
+	if err = saveSnapshot(ctx, cli, tmpSnapshot); err != nil {
+		_ = os.Remove(tmpSnapshot)
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make snapshot of `%s`. Error: %s", tgtName, err)
+		return err
+	}
+
+	// verify the snapshot and collect its status
+	if mtd.DBSize, mtd.SHA256, err = verifySnapshot(tmpSnapshot); err != nil {
+		_ = os.Remove(tmpSnapshot)
+		logCh <- logger.Log(j.name, "").Errorf("Snapshot of `%s` verification failed: %s", tgtName, err)
+		return err
+	}
+	if mtd.Revision, mtd.TotalKey, mtd.Hash, err = getSnapshotStatus(tmpSnapshot); err != nil {
+		_ = os.Remove(tmpSnapshot)
+		logCh <- logger.Log(j.name, "").Errorf("Unable to read status of `%s` snapshot. Error: %s", tgtName, err)
+		return err
+	}
+	logCh <- logger.Log(j.name, "").Infof("Snapshot verified: revision %d, total keys %d, hash %d", mtd.Revision, mtd.TotalKey, mtd.Hash)
+
+	if tgt.gzip {
+		if err = targz.GZip(tmpSnapshot, tmpBackupFile); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to archivate tmp backup: %s", err)
+			return err
+		}
+		_ = os.Remove(tmpSnapshot)
+	}
+
+	dumpObj := interfaces.DumpObject{TmpFile: tmpBackupFile}
+	mtdFile := tmpBackupFile + ".meta.json"
+	if err = writeSnapshotMetadata(mtdFile, mtd); err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to save snapshot metadata. Error: %s", err)
+	} else {
+		dumpObj.MetaFiles = []string{mtdFile}
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Snapshot of source `%s` completed", tgtName)
+	logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+	j.dumpedObjects[tgtName] = dumpObj
+
+	return nil
+}
+
+func saveSnapshot(ctx context.Context, cli *clientv3.Client, filePath string) error {
+	rd, err := cli.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rd.Close() }()
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, err = io.Copy(file, rd); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// verifySnapshot checks the sha256 checksum appended by etcd to the snapshot and returns the size of db and its checksum
+func verifySnapshot(filePath string) (int64, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	// bolt db file consists of 512 bytes pages, the rest is the checksum
+	size := fi.Size()
+	if size%512 != sha256.Size {
+		return 0, "", fmt.Errorf("snapshot checksum not found")
+	}
+	dbSize := size - sha256.Size
+
+	h := sha256.New()
+	if _, err = io.CopyN(h, file, dbSize); err != nil {
+		return 0, "", err
+	}
+	sum := make([]byte, sha256.Size)
+	if _, err = io.ReadFull(file, sum); err != nil {
+		return 0, "", err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return 0, "", fmt.Errorf("snapshot checksum mismatch")
+	}
+
+	return dbSize, hex.EncodeToString(sum), nil
+}
+
+// getSnapshotStatus opens the snapshot db and returns its revision, count of keys and crc32 hash of all buckets
+func getSnapshotStatus(filePath string) (revision int64, totalKey int, hash uint32, err error) {
+	db, err := bolt.Open(filePath, 0400, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+
+	h := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	err = db.View(func(tx *bolt.Tx) error {
+		var dbErrs []string
+		for dbErr := range tx.Check() {
+			dbErrs = append(dbErrs, dbErr.Error())
+		}
+		if len(dbErrs) > 0 {
+			return fmt.Errorf("snapshot integrity check failed: %s", strings.Join(dbErrs, "; "))
+		}
+
+		c := tx.Cursor()
+		for name, _ := c.First(); name != nil; name, _ = c.Next() {
+			b := tx.Bucket(name)
+			if b == nil {
+				return fmt.Errorf("cannot get hash of bucket %s", name)
+			}
+			_, _ = h.Write(name)
+			isKeyBucket := string(name) == "key"
+			if err := b.ForEach(func(k, v []byte) error {
+				_, _ = h.Write(k)
+				_, _ = h.Write(v)
+				// keys of the `key` bucket are revisions, the main revision is the first 8 bytes
+				if isKeyBucket && len(k) >= 8 {
+					revision = int64(binary.BigEndian.Uint64(k[:8]))
+				}
+				totalKey++
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	hash = h.Sum32()
+
+	return
+}
+
+func writeSnapshotMetadata(filePath string, mtd snapshotMetadata) error {
+	data, err := json.MarshalIndent(mtd, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"nxs-backup/modules/connectors/etcd_connect"
+	"nxs-backup/modules/logger"
+)
+
+// TestSnapshot runs against etcd server, e.g. a local single-node one with ETCD_ENDPOINTS=127.0.0.1:2379.
+// Endpoints are comma-separated. The test is skipped if ETCD_ENDPOINTS isn't set
+func TestSnapshot(t *testing.T) {
+	endpoints := os.Getenv("ETCD_ENDPOINTS")
+	if endpoints == "" {
+		t.Skip("ETCD_ENDPOINTS isn't set")
+	}
+	params := etcd_connect.Params{Endpoints: strings.Split(endpoints, ",")}
+
+	cli, _, err := etcd_connect.GetConnect(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cli.Close() })
+
+	prefix := fmt.Sprintf("/nxs-backup-test-%d/", time.Now().UnixNano())
+	resp, err := cli.Put(context.Background(), prefix+"key", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = cli.Delete(context.Background(), prefix, clientv3.WithPrefix()) })
+
+	jb, err := Init(JobParams{
+		Name:    "test",
+		TmpDir:  t.TempDir(),
+		Sources: []SourceParams{{Name: "etcd", ConnectParams: params}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := jb.(*job)
+
+	logCh := make(chan logger.LogRecord)
+	go func() {
+		for range logCh {
+		}
+	}()
+	t.Cleanup(func() { close(logCh) })
+
+	t.Run("plain", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "etcd.db")
+		if err := j.createTmpBackup(logCh, tmpFile, "etcd", target{connParams: params}); err != nil {
+			t.Fatalf("createTmpBackup() error = %v", err)
+		}
+
+		dbSize, sum, err := verifySnapshot(tmpFile)
+		if err != nil {
+			t.Fatalf("verifySnapshot() error = %v", err)
+		}
+		mtd := readMetadata(t, tmpFile+".meta.json")
+		if mtd.DBSize != dbSize || mtd.SHA256 != sum {
+			t.Errorf("metadata db size and sha256 = %d, %s, want %d, %s", mtd.DBSize, mtd.SHA256, dbSize, sum)
+		}
+		if mtd.Revision < resp.Header.Revision {
+			t.Errorf("metadata revision = %d, want at least %d", mtd.Revision, resp.Header.Revision)
+		}
+		if mtd.TotalKey == 0 || mtd.ClusterID == "" || mtd.Version == "" {
+			t.Errorf("metadata is incomplete: %+v", mtd)
+		}
+		if dumpObj := j.dumpedObjects["etcd"]; dumpObj.TmpFile != tmpFile {
+			t.Errorf("dump object file = %s, want %s", dumpObj.TmpFile, tmpFile)
+		}
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "etcd.db.gz")
+		if err := j.createTmpBackup(logCh, tmpFile, "etcd", target{connParams: params, gzip: true}); err != nil {
+			t.Fatalf("createTmpBackup() error = %v", err)
+		}
+		if _, err := os.Stat(tmpFile); err != nil {
+			t.Error(err)
+		}
+		// the raw snapshot is removed after compression
+		if _, err := os.Stat(strings.TrimSuffix(tmpFile, ".gz")); !os.IsNotExist(err) {
+			t.Errorf("raw snapshot isn't removed: %v", err)
+		}
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		tmpFile := filepath.Join(t.TempDir(), "etcd.db")
+		if err := j.createTmpBackup(logCh, tmpFile, "etcd", target{connParams: params}); err != nil {
+			t.Fatalf("createTmpBackup() error = %v", err)
+		}
+
+		data, err := os.ReadFile(tmpFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)/2] ^= 0xff
+		if err = os.WriteFile(tmpFile, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = verifySnapshot(tmpFile); err == nil {
+			t.Error("verifySnapshot() of corrupted snapshot succeeded")
+		}
+
+		// the snapshot without the checksum can't be verified
+		if err = os.WriteFile(tmpFile, data[:len(data)-1], 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = verifySnapshot(tmpFile); err == nil {
+			t.Error("verifySnapshot() of truncated snapshot succeeded")
+		}
+	})
+}
+
+func readMetadata(t *testing.T, file string) (mtd snapshotMetadata) {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &mtd); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
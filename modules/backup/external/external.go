@@ -1,51 +1,166 @@
 package external
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/go-multierror"
 
 	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/targz"
 	"nxs-backup/modules/logger"
 )
 
 type job struct {
 	name             string
+	tmpDir           string
 	dumpCmd          string
 	args             []string
 	envs             map[string]string
+	envIsolated      bool
+	workDir          string
+	runAs            *user.User
+	credential       *syscall.Credential
 	needToMakeBackup bool
 	safetyBackup     bool
 	skipBackupRotate bool
+	streamOutput     bool
 	storages         interfaces.Storages
 	dumpedObjects    map[string]interfaces.DumpObject
 }
 
 type JobParams struct {
 	Name             string
+	TmpDir           string
 	DumpCmd          string
 	Args             []string
 	Envs             map[string]string
+	EnvIsolated      bool   // The script gets only Envs and the nxs-backup variables instead of the whole environment
+	WorkDir          string // Working directory of the script
+	RunAsUser        string // Name or uid of the user the script is run as
 	NeedToMakeBackup bool
 	SafetyBackup     bool
 	SkipBackupRotate bool
+	StreamOutput     bool // Backup payload is read from the script stdout
 	Storages         interfaces.Storages
 }
 
+// output is the execution result the script writes to stdout. The first protocol version contains only `full_path`
+// of the single artifact, the second one contains the list of artifacts
+type output struct {
+	Version   int        `json:"version"`
+	FullPath  string     `json:"full_path"`
+	Artifacts []artifact `json:"artifacts"`
+}
+
+type artifact struct {
+	Ofs      string                 `json:"ofs"`
+	FullPath string                 `json:"full_path"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// stderrLine is a structured log line the script may write to stderr in the stream mode
+type stderrLine struct {
+	Level   string `json:"level"`
+	Msg     string `json:"msg"`
+	Message string `json:"message"`
+}
+
 func Init(jp JobParams) (interfaces.Job, error) {
 
-	return &job{
+	if jp.StreamOutput {
+		if jp.TmpDir == "" {
+			return nil, fmt.Errorf("Job `%s` init failed. `tmp_dir` is required when `stream_output` is enabled ", jp.Name)
+		}
+		if jp.SkipBackupRotate {
+			return nil, fmt.Errorf("Job `%s` init failed. `stream_output` can't be used with `skip_backup_rotate` ", jp.Name)
+		}
+	}
+
+	if jp.WorkDir != "" {
+		if fi, err := os.Stat(jp.WorkDir); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("Job `%s` init failed. Working directory `%s` doesn't exist ", jp.Name, jp.WorkDir)
+		}
+	}
+
+	j := &job{
 		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
 		dumpCmd:          jp.DumpCmd,
 		args:             jp.Args,
 		envs:             jp.Envs,
+		envIsolated:      jp.EnvIsolated,
+		workDir:          jp.WorkDir,
 		needToMakeBackup: jp.NeedToMakeBackup,
 		safetyBackup:     jp.SafetyBackup,
 		skipBackupRotate: jp.SkipBackupRotate,
+		streamOutput:     jp.StreamOutput,
 		storages:         jp.Storages,
 		dumpedObjects:    make(map[string]interfaces.DumpObject),
-	}, nil
+	}
+
+	if jp.RunAsUser != "" {
+		u, err := lookupUser(jp.RunAsUser)
+		if err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Unable to find user `%s`. Error: %s ", jp.Name, jp.RunAsUser, err)
+		}
+		if j.credential, err = getCredential(u); err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Unable to get ids of user `%s`. Error: %s ", jp.Name, jp.RunAsUser, err)
+		}
+		j.runAs = u
+	}
+
+	return j, nil
+}
+
+// lookupUser finds the user by name or uid
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if err == nil {
+		return u, nil
+	}
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		return user.LookupId(name)
+	}
+	return nil, err
+}
+
+func getCredential(u *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+
+	groupIds, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groupIds {
+		id, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		cred.Groups = append(cred.Groups, uint32(id))
+	}
+
+	return cred, nil
 }
 
 func (j *job) GetName() string {
@@ -53,15 +168,23 @@ func (j *job) GetName() string {
 }
 
 func (j *job) GetTempDir() string {
-	return ""
+	return j.tmpDir
 }
 
 func (j *job) GetType() string {
 	return "external"
 }
 
-func (j *job) GetTargetOfsList() []string {
-	return []string{""}
+// GetTargetOfsList returns ofs of the artifacts made by the script. Until the script is finished the job name is used,
+// as it's ofs of the single artifact of the first protocol version
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.dumpedObjects {
+		ofsList = append(ofsList, ofs)
+	}
+	if len(ofsList) == 0 {
+		ofsList = []string{j.name}
+	}
+	return
 }
 
 func (j *job) GetStoragesCount() int {
@@ -101,7 +224,7 @@ func (j *job) CleanupTmpData() error {
 	return j.storages.CleanupTmpData(j)
 }
 
-func (j *job) DoBackup(logCh chan logger.LogRecord, _ string) (err error) {
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) (err error) {
 
 	var stderr, stdout bytes.Buffer
 
@@ -111,19 +234,23 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, _ string) (err error) {
 		}
 	}()
 
-	cmd := exec.Command(j.dumpCmd, j.args...)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if len(j.envs) > 0 {
-		var envs []string
-		for k, v := range j.envs {
-			envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+	// the private tmp dir is accessible only by its owner
+	if j.credential != nil && tmpDir != "" {
+		if err = os.Chown(tmpDir, int(j.credential.Uid), int(j.credential.Gid)); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to change owner of tmp dir. Error: %s", err)
+			return err
 		}
-		cmd.Env = envs
 	}
 
-	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+	if j.streamOutput {
+		return j.doStreamBackup(logCh, tmpDir)
+	}
+
+	cmd := j.getCmd(tmpDir)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
 
 	if err = cmd.Start(); err != nil {
 		logCh <- logger.Log(j.name, "").Errorf("Unable to start %s. Error: %s", j.dumpCmd, err)
@@ -145,22 +272,221 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, _ string) (err error) {
 		return
 	}
 
-	var out struct {
-		FullPath string `json:"full_path"`
-	}
-	err = json.Unmarshal(stdout.Bytes(), &out)
+	artifacts, version, err := j.parseOutput(stdout.Bytes())
 	if err != nil {
 		logCh <- logger.Log(j.name, "").Errorf("Unable to parse execution result. Error: %s", err)
 		return err
 	}
 
-	logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s.", out.FullPath)
+	for _, a := range artifacts {
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s.", a.FullPath)
+
+		dumpObj := interfaces.DumpObject{TmpFile: a.FullPath}
+		if len(a.Metadata) > 0 {
+			mtdFile := a.FullPath + ".meta.json"
+			if err = writeMetadata(mtdFile, a.Metadata); err != nil {
+				logCh <- logger.Log(j.name, "").Warnf("Unable to save metadata of `%s` artifact. Error: %s", a.Ofs, err)
+			} else {
+				dumpObj.MetaFiles = []string{mtdFile}
+			}
+		}
+		j.dumpedObjects[a.Ofs] = dumpObj
+	}
+
+	err = j.storages.Delivery(logCh, j)
 
-	j.dumpedObjects[j.name] = interfaces.DumpObject{TmpFile: out.FullPath}
+	// artifacts of the second protocol version aren't known before the script run,
+	// so they are rotated after the delivery
+	if version == 2 && !j.safetyBackup {
+		if rErr := j.DeleteOldBackups(logCh, ""); rErr != nil {
+			err = multierror.Append(err, rErr)
+		}
+	}
+
+	return err
+}
+
+// parseOutput returns the artifacts listed in the script output and the protocol version
+func (j *job) parseOutput(data []byte) ([]artifact, int, error) {
+	var out output
+
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, 0, err
+	}
+
+	switch out.Version {
+	case 0, 1:
+		if out.FullPath == "" {
+			return nil, 1, fmt.Errorf("`full_path` is empty")
+		}
+		return []artifact{{Ofs: j.name, FullPath: out.FullPath}}, 1, nil
+	case 2:
+		if len(out.Artifacts) == 0 {
+			return nil, 2, fmt.Errorf("no artifacts listed")
+		}
+		seen := make(map[string]bool)
+		for i, a := range out.Artifacts {
+			ofs := path.Clean(a.Ofs)
+			if a.Ofs == "" || path.IsAbs(ofs) || ofs == ".." || strings.HasPrefix(ofs, "../") {
+				return nil, 2, fmt.Errorf("invalid ofs `%s` of artifact %d", a.Ofs, i)
+			}
+			if a.FullPath == "" {
+				return nil, 2, fmt.Errorf("`full_path` of `%s` artifact is empty", a.Ofs)
+			}
+			if seen[ofs] {
+				return nil, 2, fmt.Errorf("duplicate ofs `%s`", a.Ofs)
+			}
+			seen[ofs] = true
+			out.Artifacts[i].Ofs = ofs
+		}
+		return out.Artifacts, 2, nil
+	default:
+		return nil, out.Version, fmt.Errorf("unsupported protocol version %d", out.Version)
+	}
+}
+
+func writeMetadata(filePath string, metadata map[string]interface{}) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// doStreamBackup saves the script stdout into the compressed tmp file and forwards the script stderr lines to the log
+func (j *job) doStreamBackup(logCh chan logger.LogRecord, tmpDir string) error {
+
+	tmpBackupFile := misc.GetFileFullPath(tmpDir, j.name, "out", "", true)
+	writer, err := targz.GetFileWriter(tmpBackupFile, true)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp file. Error: %s", err)
+		return err
+	}
+	defer func() { _ = writer.Close() }()
+
+	stdout := &countWriter{w: writer}
+
+	cmd := j.getCmd(tmpDir)
+	cmd.Stdout = stdout
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to get stderr of %s. Error: %s", j.dumpCmd, err)
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
+
+	if err = cmd.Start(); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to start %s. Error: %s", j.dumpCmd, err)
+		return err
+	}
+	logCh <- logger.Log(j.name, "").Infof("Starting of `%s`", j.dumpCmd)
+
+	// all stderr must be read before the command waiting
+	j.forwardStderr(logCh, stderr)
+
+	if err = cmd.Wait(); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to finish `%s`. Error: %s", j.dumpCmd, err)
+		return err
+	}
+
+	if err = writer.Close(); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to write tmp backup. Error: %s", err)
+		return err
+	}
+	if stdout.n == 0 {
+		err = fmt.Errorf("`%s` has written nothing to stdout", j.dumpCmd)
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backup. Error: %s", err)
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Dumping completed")
+	logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s.", tmpBackupFile)
+
+	j.dumpedObjects[j.name] = interfaces.DumpObject{TmpFile: tmpBackupFile}
 
 	return j.storages.Delivery(logCh, j)
 }
 
+// forwardStderr sends the script stderr lines to the log. JSON lines like `{"level": "warn", "msg": "..."}` are logged
+// with the specified level, other lines are logged with the info level
+func (j *job) forwardStderr(logCh chan logger.LogRecord, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var l stderrLine
+		if !strings.HasPrefix(line, "{") || json.Unmarshal([]byte(line), &l) != nil {
+			logCh <- logger.Log(j.name, "").Info(line)
+			continue
+		}
+		msg := l.Msg
+		if msg == "" {
+			msg = l.Message
+		}
+
+		switch strings.ToLower(l.Level) {
+		case "debug", "trace":
+			logCh <- logger.Log(j.name, "").Debug(msg)
+		case "warn", "warning":
+			logCh <- logger.Log(j.name, "").Warn(msg)
+		case "error", "err", "fatal":
+			logCh <- logger.Log(j.name, "").Error(msg)
+		default:
+			logCh <- logger.Log(j.name, "").Info(msg)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to read stderr of `%s`. Error: %s", j.dumpCmd, err)
+		// drain the rest of output, so the script isn't blocked on write
+		_, _ = io.Copy(io.Discard, r)
+	}
+}
+
+// getCmd prepares the script command. The script gets the parent environment (unless it's isolated), the variables
+// from the job config and the nxs-backup variables
+func (j *job) getCmd(tmpDir string) *exec.Cmd {
+	cmd := exec.Command(j.dumpCmd, j.args...)
+	cmd.Dir = j.workDir
+
+	var envs []string
+	if !j.envIsolated {
+		envs = os.Environ()
+	}
+	if j.runAs != nil {
+		envs = append(envs, "HOME="+j.runAs.HomeDir, "USER="+j.runAs.Username, "LOGNAME="+j.runAs.Username)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: j.credential}
+	}
+	for k, v := range j.envs {
+		envs = append(envs, fmt.Sprintf("%s=%s", k, v))
+	}
+	// later values take precedence, so the nxs-backup variables can't be overridden
+	envs = append(envs,
+		"NXS_BACKUP_JOB_NAME="+j.name,
+		"NXS_BACKUP_TMP_DIR="+tmpDir,
+	)
+	cmd.Env = envs
+
+	return cmd
+}
+
+// countWriter counts the bytes written to the underlying writer
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
 func (j *job) Close() error {
 	for _, st := range j.storages {
 		_ = st.Close()
@@ -0,0 +1,397 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mb0/glob"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/exec_cmd"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/logger"
+)
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	nonRepos         map[string]string
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	path          string
+	skipUnchanged bool
+	gzip          bool
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type SourceParams struct {
+	Name          string
+	Targets       []string
+	Excludes      []string
+	SkipUnchanged bool
+	Gzip          bool
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	// check if git available
+	if _, err := exec_cmd.Exec("git", "--version"); err != nil {
+		return nil, fmt.Errorf("Job `%s` init failed. Can't check `git` version. Please install `git`. Error: %s ", jp.Name, err)
+	}
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		nonRepos:         make(map[string]string),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for _, src := range jp.Sources {
+
+		for _, targetPattern := range src.Targets {
+
+			targetOfsList, err := filepath.Glob(targetPattern)
+			if err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to process pattern: %s. Error: %s. ", jp.Name, targetPattern, err)
+			}
+
+			for _, ofs := range targetOfsList {
+				skipOfs := false
+				for _, pattern := range src.Excludes {
+					match, err := glob.Match(pattern, ofs)
+					if err != nil {
+						return nil, fmt.Errorf("Job `%s` init failed. Unable to process pattern: %s. Error: %s. ", jp.Name, pattern, err)
+					}
+					if match {
+						skipOfs = true
+						break
+					}
+				}
+				if skipOfs {
+					continue
+				}
+
+				// only git repositories are backed up, other paths are reported on backup
+				if out, err := gitCmd(ofs, "rev-parse", "--git-dir").CombinedOutput(); err != nil {
+					j.nonRepos[ofs] = fmt.Sprintf("%s %s", err, strings.TrimSpace(string(out)))
+					continue
+				}
+
+				ofsPart := src.Name + "/" + misc.GetOfsPart(targetPattern, ofs)
+				j.targets[ofsPart] = target{
+					path:          ofs,
+					skipUnchanged: src.SkipUnchanged,
+					gzip:          src.Gzip,
+				}
+			}
+		}
+	}
+
+	return j, nil
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "git"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return false
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofs, reason := range j.nonRepos {
+		logCh <- logger.Log(j.name, "").Warnf("Path `%s` isn't a git repository, skipping. Error: %s", ofs, reason)
+	}
+
+	for ofsPart, tgt := range j.targets {
+
+		refsHash, err := getRefsHash(tgt.path)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to get refs of `%s` repository. Error: %s", tgt.path, err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if refsHash == "" {
+			logCh <- logger.Log(j.name, "").Warnf("Repository `%s` has no refs, skipping", tgt.path)
+			continue
+		}
+		refsFileName := path.Base(ofsPart) + ".refs"
+		if tgt.skipUnchanged && j.hasActualBundles(logCh, ofsPart, refsFileName, refsHash) {
+			logCh <- logger.Log(j.name, "").Infof("Refs of `%s` repository have not changed since the last backup, skipping", tgt.path)
+			continue
+		}
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "bundle", "", tgt.gzip)
+		err = os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if err = j.createTmpBackup(logCh, tmpBackupFile, tgt); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+		dumpObj := interfaces.DumpObject{TmpFile: tmpBackupFile}
+		// the refs hash file has the constant name, so the latest one can be fetched from storages on the next run.
+		// The creation time is saved next to the hash to refresh the bundle before it leaves the retention window
+		refsFile := path.Join(path.Dir(tmpBackupFile), refsFileName)
+		refsData := refsHash + "\n" + time.Now().Format(time.RFC3339) + "\n"
+		if err = os.WriteFile(refsFile, []byte(refsData), 0644); err != nil {
+			logCh <- logger.Log(j.name, "").Warnf("Unable to save refs hash. Error: %s", err)
+		} else {
+			dumpObj.MetaFiles = []string{refsFile}
+		}
+
+		j.dumpedObjects[ofsPart] = dumpObj
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// createTmpBackup exports all refs of the repository into the bundle and verifies it
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string, tgt target) error {
+
+	tmpBundle := strings.TrimSuffix(tmpBackupFile, ".gz")
+
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` backup", tgt.path)
+
+	if err := j.execGit(logCh, tgt.path, "bundle", "create", tmpBundle, "--all"); err != nil {
+		_ = os.Remove(tmpBundle)
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create bundle of `%s`. Error: %s", tgt.path, err)
+		return err
+	}
+
+	if err := j.execGit(logCh, tgt.path, "bundle", "verify", "--quiet", tmpBundle); err != nil {
+		_ = os.Remove(tmpBundle)
+		logCh <- logger.Log(j.name, "").Errorf("Bundle of `%s` verification failed. Error: %s", tgt.path, err)
+		return err
+	}
+
+	if tgt.gzip {
+		if err := targz.GZip(tmpBundle, tmpBackupFile); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to archivate tmp backup: %s", err)
+			return err
+		}
+		_ = os.Remove(tmpBundle)
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Backup of `%s` completed", tgt.path)
+
+	return nil
+}
+
+// hasActualBundles checks that each storage keeps a bundle with the same refs hash which won't be removed by
+// the rotation until the next run. The bundle is considered outdated a day before its retention period expires,
+// so the unchanged repository is backed up again while the previous bundle still exists
+func (j *job) hasActualBundles(logCh chan logger.LogRecord, ofsPart, refsFileName, refsHash string) bool {
+	if len(j.storages) == 0 {
+		return false
+	}
+
+	for _, st := range j.storages {
+		if !j.hasActualBundle(logCh, st, ofsPart, refsFileName, refsHash) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (j *job) hasActualBundle(logCh chan logger.LogRecord, st interfaces.Storage, ofsPart, refsFileName, refsHash string) bool {
+	retention := st.GetRetention()
+
+	for _, period := range []string{"daily", "weekly", "monthly"} {
+		hash, created, err := readStoredRefs(st, path.Join(ofsPart, period, refsFileName))
+		if err != nil {
+			logCh <- logger.Log(j.name, st.GetName()).Debugf("Unable to read refs hash of previous %s backup. Error: %s ", period, err)
+			continue
+		}
+		if hash != refsHash {
+			continue
+		}
+
+		var expires time.Time
+		switch period {
+		case "daily":
+			expires = created.AddDate(0, 0, retention.Days)
+		case "weekly":
+			expires = created.AddDate(0, 0, retention.Weeks*7)
+		case "monthly":
+			expires = created.AddDate(0, retention.Months, 0)
+		}
+		if time.Now().Before(expires.AddDate(0, 0, -1)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readStoredRefs returns the refs hash and the creation time saved with the bundle
+func readStoredRefs(st interfaces.Storage, refsPath string) (hash string, created time.Time, err error) {
+	reader, err := st.GetFileReader(refsPath)
+	if err != nil {
+		return
+	}
+	data, err := io.ReadAll(reader)
+	if c, ok := reader.(io.Closer); ok {
+		_ = c.Close()
+	}
+	if err != nil {
+		return
+	}
+
+	lines := strings.Fields(string(data))
+	if len(lines) < 2 {
+		err = fmt.Errorf("creation time of the bundle is unknown")
+		return
+	}
+	hash = lines[0]
+	created, err = time.Parse(time.RFC3339, lines[1])
+
+	return
+}
+
+// getRefsHash returns sha256 hash of all refs of the repository with the objects they point to.
+// Empty string is returned if the repository has no refs
+func getRefsHash(repoPath string) (string, error) {
+	var stderr, stdout bytes.Buffer
+
+	cmd := gitCmd(repoPath, "for-each-ref", "--format=%(objectname) %(refname)")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s", err, stderr.String())
+	}
+	if stdout.Len() == 0 {
+		return "", nil
+	}
+
+	sum := sha256.Sum256(stdout.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (j *job) execGit(logCh chan logger.LogRecord, repoPath string, args ...string) error {
+	var stderr, stdout bytes.Buffer
+
+	cmd := gitCmd(repoPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+// gitCmd returns git command executed in the repository. Repositories are usually owned by another user (e.g. `git`),
+// so the ownership check of git 2.35.2+ is disabled for the command, otherwise git refuses to work with them
+func gitCmd(repoPath string, args ...string) *exec.Cmd {
+	return exec.Command("git", append([]string{"-c", "safe.directory=*", "-C", repoPath}, args...)...)
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
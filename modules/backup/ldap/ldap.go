@@ -0,0 +1,408 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/logger"
+)
+
+const (
+	methodSlapcat    = "slapcat"
+	methodLdapsearch = "ldapsearch"
+)
+
+// page size of the paged results control of ldapsearch
+const pageSize = 500
+
+// operationalAttrs are operational attributes accepted by slapadd. Others, like entryDN, subschemaSubentry,
+// hasSubordinates or memberOf, are generated by the server and aren't requested, so slapadd is able to load the LDIF
+var operationalAttrs = []string{
+	"entryUUID",
+	"entryCSN",
+	"createTimestamp",
+	"modifyTimestamp",
+	"creatorsName",
+	"modifiersName",
+	"structuralObjectClass",
+}
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	method      string
+	database    string
+	slapdConfig string
+	connParams  ConnectParams
+	gzip        bool
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type ConnectParams struct {
+	URI       string // LDAP URI, e.g. `ldaps://ldap.example.com`
+	Host      string // Network host, used if URI isn't set
+	Port      string // Network port, used if URI isn't set
+	BindDN    string // DN to bind with, anonymous bind is used if empty
+	Passwd    string // Bind password
+	StartTLS  bool   // Whether to issue StartTLS request
+	TLSCAFile string // Path to TLS CA file
+}
+
+type SourceParams struct {
+	Name          string
+	Method        string   // `slapcat` or `ldapsearch`
+	TargetDBs     []string // Database numbers or suffixes
+	Excludes      []string // Suffixes to be excluded
+	SlapdConfig   string   // Path to slapd config file or config directory. Used by `slapcat` only
+	ConnectParams ConnectParams
+	Gzip          bool
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for _, src := range jp.Sources {
+
+		method := src.Method
+		if method == "" {
+			method = methodSlapcat
+		}
+		if method != methodSlapcat && method != methodLdapsearch {
+			return nil, fmt.Errorf("Job `%s` init failed. Unknown method `%s` of source `%s`. Allowed methods: slapcat, ldapsearch ", jp.Name, src.Method, src.Name)
+		}
+		// check if tool available
+		if _, err := exec.LookPath(method); err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Can't find `%s`. Please install it. Error: %s ", jp.Name, method, err)
+		}
+
+		databases := src.TargetDBs
+		if misc.Contains(src.TargetDBs, "all") {
+			if method != methodLdapsearch {
+				return nil, fmt.Errorf("Job `%s` init failed. The keyword `all` is supported by `ldapsearch` method only, define database numbers or suffixes of source `%s` ", jp.Name, src.Name)
+			}
+			var err error
+			if databases, err = getNamingContexts(src.ConnectParams); err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to get naming contexts. Error: %s ", jp.Name, err)
+			}
+		}
+
+		for _, db := range databases {
+			if misc.Contains(src.Excludes, db) {
+				continue
+			}
+			if _, err := strconv.Atoi(db); err == nil && method == methodLdapsearch {
+				return nil, fmt.Errorf("Job `%s` init failed. Database numbers are supported by `slapcat` method only, define suffix instead of `%s` ", jp.Name, db)
+			}
+
+			j.targets[src.Name+"/"+ofsName(db)] = target{
+				method:      method,
+				database:    db,
+				slapdConfig: src.SlapdConfig,
+				connParams:  src.ConnectParams,
+				gzip:        src.Gzip,
+			}
+		}
+	}
+
+	return j, nil
+}
+
+// ofsName returns the name of the backup for the database number or suffix
+func ofsName(db string) string {
+	if _, err := strconv.Atoi(db); err == nil {
+		return "db_" + db
+	}
+	return strings.ReplaceAll(db, "/", "_")
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "ldap"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return false
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofsPart, tgt := range j.targets {
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "ldif", "", tgt.gzip)
+		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if err = j.createTmpBackup(logCh, tmpBackupFile, tgt); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+		j.dumpedObjects[ofsPart] = interfaces.DumpObject{TmpFile: tmpBackupFile}
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string, tgt target) error {
+	var stderr bytes.Buffer
+
+	logCh <- logger.Log(j.name, "").Infof("Starting to export `%s`", tgt.database)
+
+	var cmd *exec.Cmd
+	if tgt.method == methodSlapcat {
+		cmd = exec.Command("slapcat", slapcatArgs(tgt)...)
+	} else {
+		args, pwFile, err := ldapsearchConnArgs(tgt.connParams)
+		if pwFile != "" {
+			defer func() { _ = os.Remove(pwFile) }()
+		}
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to save password file. Error: %s", err)
+			return err
+		}
+		// all user attributes and only operational attributes stored in the database are exported
+		args = append(args, "-E", fmt.Sprintf("pr=%d/noprompt", pageSize), "-b", tgt.database, "-s", "sub",
+			"(objectClass=*)", "*")
+		args = append(args, operationalAttrs...)
+		cmd = exec.Command("ldapsearch", args...)
+		cmd.Env = ldapsearchEnv(tgt.connParams)
+	}
+
+	w, err := targz.GetFileWriter(tmpBackupFile, tgt.gzip)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp file. Error: %s", err)
+		return err
+	}
+	defer func() { _ = w.Close() }()
+
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+
+	if err = cmd.Run(); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to export `%s`. Error: %s", tgt.database, err)
+		logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", stderr.String())
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Export of `%s` completed", tgt.database)
+
+	return nil
+}
+
+func slapcatArgs(tgt target) (args []string) {
+	if tgt.slapdConfig != "" {
+		if fi, err := os.Stat(tgt.slapdConfig); err == nil && fi.IsDir() {
+			args = append(args, "-F", tgt.slapdConfig)
+		} else {
+			args = append(args, "-f", tgt.slapdConfig)
+		}
+	}
+	if _, err := strconv.Atoi(tgt.database); err == nil {
+		args = append(args, "-n", tgt.database)
+	} else {
+		args = append(args, "-b", tgt.database)
+	}
+	return
+}
+
+// ldapsearchConnArgs returns connection args of ldapsearch. The password is passed via the file in the private tmp dir,
+// which has to be removed after the run
+func ldapsearchConnArgs(params ConnectParams) (args []string, pwFile string, err error) {
+	uri := params.URI
+	if uri == "" {
+		port := params.Port
+		if port == "" {
+			port = "389"
+		}
+		uri = "ldap://" + net.JoinHostPort(params.Host, port)
+	}
+
+	args = []string{"-LLL", "-x", "-o", "ldif-wrap=no", "-H", uri}
+	if params.StartTLS {
+		args = append(args, "-ZZ")
+	}
+	if params.BindDN == "" {
+		return
+	}
+	args = append(args, "-D", params.BindDN)
+
+	dir, err := misc.GetPrivateTmpDir()
+	if err != nil {
+		return
+	}
+	file, err := os.CreateTemp(dir, "ldap_pw_*")
+	if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	pwFile = file.Name()
+
+	// the whole file content is used as the password, so no trailing newline is written
+	if _, err = file.WriteString(params.Passwd); err != nil {
+		return
+	}
+	args = append(args, "-y", pwFile)
+
+	return
+}
+
+func ldapsearchEnv(params ConnectParams) []string {
+	env := os.Environ()
+	if params.TLSCAFile != "" {
+		env = append(env, "LDAPTLS_CACERT="+params.TLSCAFile)
+	}
+	return env
+}
+
+// getNamingContexts returns suffixes of the server databases from the root DSE
+func getNamingContexts(params ConnectParams) ([]string, error) {
+	var stderr, stdout bytes.Buffer
+
+	args, pwFile, err := ldapsearchConnArgs(params)
+	if pwFile != "" {
+		defer func() { _ = os.Remove(pwFile) }()
+	}
+	if err != nil {
+		return nil, err
+	}
+	args = append(args, "-s", "base", "-b", "", "(objectClass=*)", "namingContexts")
+
+	cmd := exec.Command("ldapsearch", args...)
+	cmd.Env = ldapsearchEnv(params)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s %s", err, stderr.String())
+	}
+
+	var contexts []string
+	sc := bufio.NewScanner(&stdout)
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "namingContexts:: "):
+			dn, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "namingContexts:: "))
+			if err != nil {
+				return nil, err
+			}
+			contexts = append(contexts, string(dn))
+		case strings.HasPrefix(line, "namingContexts: "):
+			contexts = append(contexts, strings.TrimPrefix(line, "namingContexts: "))
+		}
+	}
+
+	return contexts, sc.Err()
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
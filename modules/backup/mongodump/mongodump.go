@@ -11,6 +11,7 @@ import (
 
 	"github.com/hashicorp/go-multierror"
 	"go.mongodb.org/mongo-driver/bson"
+	"gopkg.in/yaml.v3"
 
 	"nxs-backup/interfaces"
 	"nxs-backup/misc"
@@ -20,6 +21,12 @@ import (
 	"nxs-backup/modules/logger"
 )
 
+const (
+	modeCollections = "collections"
+	modeDatabase    = "database"
+	modeInstance    = "instance"
+)
+
 type job struct {
 	name             string
 	tmpDir           string
@@ -38,6 +45,10 @@ type target struct {
 	collections []string
 	extraKeys   []string
 	gzip        bool
+	mode        string
+	oplog       bool
+	// collections excluded from database archive dumps
+	excludeCollections []string
 }
 
 type JobParams struct {
@@ -59,6 +70,8 @@ type SourceParams struct {
 	ExcludeCollections []string
 	ExtraKeys          []string
 	Gzip               bool
+	DumpMode           string // `collections`, `database` or `instance`
+	Oplog              bool   // Whether to capture oplog entries during the dump. Only for `instance` mode
 }
 
 func Init(jp JobParams) (interfaces.Job, error) {
@@ -85,12 +98,42 @@ func Init(jp JobParams) (interfaces.Job, error) {
 
 	for _, src := range jp.Sources {
 
+		if src.DumpMode == "" {
+			src.DumpMode = modeCollections
+		}
+		if !misc.Contains([]string{modeCollections, modeDatabase, modeInstance}, src.DumpMode) {
+			return nil, fmt.Errorf("Job `%s` init failed. Unsupported dump mode `%s` of source `%s`. Allowed modes: collections, database, instance ", jp.Name, src.DumpMode, src.Name)
+		}
+		if src.DumpMode == modeInstance && hasNamespaceFilters(src) {
+			if src.Oplog {
+				return nil, fmt.Errorf("Job `%s` init failed. Oplog can be captured only in `instance` dump mode of the whole instance without databases and collections filters, source `%s` ", jp.Name, src.Name)
+			}
+			// mongodump filters collections only within one database, so the filtered instance is dumped by databases
+			src.DumpMode = modeDatabase
+		}
+		if src.Oplog && src.DumpMode != modeInstance {
+			return nil, fmt.Errorf("Job `%s` init failed. Oplog can be captured only in `instance` dump mode, source `%s` ", jp.Name, src.Name)
+		}
+
 		conn, host, err := mongo_connect.GetConnectAndHost(src.ConnectParams)
 		if err != nil {
 			return nil, fmt.Errorf("Job `%s` init failed. MongoDB connect error: %s ", jp.Name, err)
 		}
 		defer func() { _ = conn.Disconnect(context.TODO()) }()
 
+		if src.DumpMode == modeInstance {
+			j.targets[src.Name] = target{
+				dbName:    "all",
+				host:      host,
+				extraKeys: src.ExtraKeys,
+				gzip:      src.Gzip,
+				connOpts:  src.ConnectParams,
+				mode:      modeInstance,
+				oplog:     src.Oplog,
+			}
+			continue
+		}
+
 		// fetch databases list to make backup
 		var databases []string
 		if misc.Contains(src.TargetDBs, "all") {
@@ -120,6 +163,32 @@ func Init(jp JobParams) (interfaces.Job, error) {
 				}
 			}
 
+			if src.DumpMode == modeDatabase {
+				// mongodump can dump only one collection with `--collection` option,
+				// so collections out of the targets are excluded instead
+				if !isAllCollectionsFlag {
+					collections, err := conn.Database(db).ListCollectionNames(context.TODO(), bson.D{})
+					if err != nil {
+						return nil, fmt.Errorf("Job `%s` init failed. Unable to list collections of database `%s`. Error: %s ", jp.Name, db, err)
+					}
+					for _, col := range collections {
+						if !misc.Contains(src.TargetCollections, col) && !misc.Contains(ignoreCollections, col) {
+							ignoreCollections = append(ignoreCollections, col)
+						}
+					}
+				}
+				j.targets[src.Name+"/"+db] = target{
+					dbName:             db,
+					host:               host,
+					extraKeys:          src.ExtraKeys,
+					gzip:               src.Gzip,
+					connOpts:           src.ConnectParams,
+					mode:               src.DumpMode,
+					excludeCollections: ignoreCollections,
+				}
+				continue
+			}
+
 			var collections, tc []string
 			if isAllCollectionsFlag {
 				collections, err = conn.Database(db).ListCollectionNames(context.TODO(), bson.D{})
@@ -143,14 +212,22 @@ func Init(jp JobParams) (interfaces.Job, error) {
 				extraKeys:   src.ExtraKeys,
 				gzip:        src.Gzip,
 				connOpts:    src.ConnectParams,
+				mode:        src.DumpMode,
 			}
-
 		}
 	}
 
 	return j, nil
 }
 
+// hasNamespaceFilters checks if the source dumps only a part of the instance
+func hasNamespaceFilters(src SourceParams) bool {
+	return !misc.Contains(src.TargetDBs, "all") ||
+		!misc.Contains(src.TargetCollections, "all") ||
+		len(src.ExcludeDBs) > 0 ||
+		len(src.ExcludeCollections) > 0
+}
+
 func (j *job) GetName() string {
 	return j.name
 }
@@ -209,7 +286,11 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 
 	for ofsPart, tgt := range j.targets {
 
-		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "tar", "", tgt.gzip)
+		ext := "tar"
+		if tgt.mode != modeCollections {
+			ext = "archive"
+		}
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, ext, "", tgt.gzip)
 
 		if err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm); err != nil {
 			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
@@ -217,7 +298,13 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 			continue
 		}
 
-		if err := j.createTmpBackup(logCh, tmpBackupFile, tgt); err != nil {
+		var err error
+		if tgt.mode == modeCollections {
+			err = j.createTmpBackup(logCh, tmpBackupFile, tgt)
+		} else {
+			err = j.createTmpArchiveBackup(logCh, tmpBackupFile, tgt)
+		}
+		if err != nil {
 			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
 			errs = multierror.Append(errs, err)
 			continue
@@ -246,24 +333,16 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string, target target) error {
 	tmpMongodumpPath := path.Join(path.Dir(tmpBackupFile), "dump")
 
-	var args []string
-	// define command args
-	// auth url
-	args = append(args, "--host="+target.host)
-	if target.connOpts.AuthDB != "" {
-		args = append(args, "--authenticationDatabase="+target.connOpts.AuthDB)
-	} else {
-		args = append(args, "--authenticationDatabase=admin")
+	args := getConnectArgs(target)
+	cfgFile, err := writeConfigFile(target)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create mongodump config file. Error: %s", err)
+		return err
 	}
-	args = append(args, "--username="+target.connOpts.User)
-	args = append(args, "--password="+target.connOpts.Passwd)
+	defer func() { _ = os.Remove(cfgFile) }()
+	args = append(args, "--config="+cfgFile)
 	// add db name
 	args = append(args, "--db="+target.dbName)
-
-	if target.connOpts.TLSCAFile != "" {
-		args = append(args, "--ssl")
-		args = append(args, "--sslCAFile="+target.connOpts.TLSCAFile)
-	}
 	// add extra dump cmd options
 	if len(target.extraKeys) > 0 {
 		args = append(args, target.extraKeys...)
@@ -279,7 +358,7 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string,
 		cmd := exec.Command("mongodump", argsCol...)
 		cmd.Stdout = &stdout
 		cmd.Stderr = &stderr
-		logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+		logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
 		if err := cmd.Run(); err != nil {
 			logCh <- logger.Log(j.name, "").Errorf("Unable to dump `%s`. Error: %s", target.dbName, err)
 			logCh <- logger.Log(j.name, "").Debugf("STDOUT: %s", stdout.String())
@@ -304,6 +383,103 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string,
 	return nil
 }
 
+// createTmpArchiveBackup dumps the database or the whole instance with one mongodump run directly into the archive file
+func (j *job) createTmpArchiveBackup(logCh chan logger.LogRecord, tmpBackupFile string, target target) error {
+
+	args := getConnectArgs(target)
+	cfgFile, err := writeConfigFile(target)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create mongodump config file. Error: %s", err)
+		return err
+	}
+	defer func() { _ = os.Remove(cfgFile) }()
+	args = append(args, "--config="+cfgFile)
+	if target.mode == modeDatabase {
+		args = append(args, "--db="+target.dbName)
+		for _, col := range target.excludeCollections {
+			args = append(args, "--excludeCollection="+col)
+		}
+	}
+	// oplog is captured only for the whole instance dumps
+	if target.oplog {
+		args = append(args, "--oplog")
+	}
+	if target.gzip {
+		args = append(args, "--gzip")
+	}
+	// add extra dump cmd options
+	if len(target.extraKeys) > 0 {
+		args = append(args, target.extraKeys...)
+	}
+	// set output
+	args = append(args, "--archive="+tmpBackupFile)
+
+	var stderr, stdout bytes.Buffer
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` dump", target.dbName)
+
+	cmd := exec.Command("mongodump", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
+	if err := cmd.Run(); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to dump `%s`. Error: %s", target.dbName, err)
+		logCh <- logger.Log(j.name, "").Debugf("STDOUT: %s", stdout.String())
+		logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", stderr.String())
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Dump of `%s` completed", target.dbName)
+
+	return nil
+}
+
+func getConnectArgs(target target) (args []string) {
+	// auth url
+	args = append(args, "--host="+target.host)
+	if target.connOpts.AuthDB != "" {
+		args = append(args, "--authenticationDatabase="+target.connOpts.AuthDB)
+	} else {
+		args = append(args, "--authenticationDatabase=admin")
+	}
+	args = append(args, "--username="+target.connOpts.User)
+
+	if target.connOpts.TLSCAFile != "" {
+		args = append(args, "--ssl")
+		args = append(args, "--sslCAFile="+target.connOpts.TLSCAFile)
+	}
+	if target.connOpts.ReadPref != "" {
+		args = append(args, "--readPreference="+target.connOpts.ReadPref)
+	}
+	return
+}
+
+// writeConfigFile saves the password into mongodump config file, so it isn't passed via command line
+func writeConfigFile(target target) (string, error) {
+	cfg, err := yaml.Marshal(map[string]string{"password": target.connOpts.Passwd})
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := misc.GetPrivateTmpDir()
+	if err != nil {
+		return "", err
+	}
+
+	// the file is created with 0600 permissions
+	file, err := os.CreateTemp(dir, "mongodump_*.yaml")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	if _, err = file.Write(cfg); err != nil {
+		_ = os.Remove(file.Name())
+		return "", err
+	}
+
+	return file.Name(), nil
+}
+
 func (j *job) Close() error {
 	for _, st := range j.storages {
 		_ = st.Close()
@@ -2,11 +2,16 @@ package mysql
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/jmoiron/sqlx"
@@ -19,33 +24,74 @@ import (
 	"nxs-backup/modules/logger"
 )
 
+// dumpHeadSize is the size of the dump beginning searched for binary log positions
+const dumpHeadSize = 1 << 20
+
+var (
+	positionCommentRegex = regexp.MustCompile(`^-- Position to start replication or point-in-time recovery from`)
+	changeSourceRegex    = regexp.MustCompile(`^(?:-- )?CHANGE (?:MASTER|REPLICATION SOURCE) TO .*?(?:MASTER|SOURCE)_LOG_FILE='([^']+)', (?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+	gtidPurgedRegex      = regexp.MustCompile(`SET @@GLOBAL\.GTID_PURGED=(?:/\*!80000 '\+'\*/ )?'([^']*)'`)
+)
+
 type job struct {
 	name             string
 	tmpDir           string
 	needToMakeBackup bool
 	safetyBackup     bool
 	deferredCopying  bool
+	parallelTargets  int
 	storages         interfaces.Storages
 	targets          map[string]target
 	dumpedObjects    map[string]interfaces.DumpObject
+	sourceDataOpt    string
+	replicaPositions map[string]*binlogPosition
 }
 
 type target struct {
+	srcName      string
 	connect      *sqlx.DB
 	authFile     string
 	dbName       string
 	ignoreTables []string
 	extraKeys    []string
 	isSlave      bool
+	slaveChecks  SlaveChecks
+	splitTables  bool
 	gzip         bool
 }
 
+type dumpResult struct {
+	ofsPart       string
+	tmpBackupFile string
+	mtdFile       string
+	err           error
+}
+
+// dumpMetadata describes the dump and is stored alongside it
+type dumpMetadata struct {
+	Database          string          `json:"database"`
+	CreatedAt         string          `json:"created_at"`
+	SingleTransaction bool            `json:"single_transaction"`
+	MasterStatus      *binlogPosition `json:"master_status,omitempty"`  // position of the dumped server binary log
+	ReplicaStatus     *binlogPosition `json:"replica_status,omitempty"` // position of the replication source binary log
+}
+
+// binlogPosition is the position to start replication or point-in-time recovery from
+type binlogPosition struct {
+	LogFile string `json:"log_file"`
+	LogPos  string `json:"log_pos"`
+	GtidSet string `json:"gtid_set,omitempty"`
+	// Consistent is set if the position exactly matches the dump, otherwise it's read right before the dump
+	Consistent bool `json:"consistent"`
+}
+
 type JobParams struct {
 	Name             string
 	TmpDir           string
 	NeedToMakeBackup bool
 	SafetyBackup     bool
 	DeferredCopying  bool
+	ParallelTargets  int
 	Storages         interfaces.Storages
 	Sources          []SourceParams
 }
@@ -58,6 +104,16 @@ type SourceParams struct {
 	ExtraKeys     []string
 	Gzip          bool
 	IsSlave       bool
+	SlaveChecks   SlaveChecks
+	SplitTables   bool
+}
+
+// SlaveChecks defines checks of the replica state made before dumping
+type SlaveChecks struct {
+	MaxLag         int    // Maximum replication lag in seconds, 0 disables the check
+	CheckThreads   bool   // Check if replication IO and SQL threads are running
+	OnFailure      string // Action on failed check: `fail` or `skip`
+	StopSQLThreads bool   // Stop only the replication SQL thread during the dump
 }
 
 func Init(jp JobParams) (interfaces.Job, error) {
@@ -67,19 +123,50 @@ func Init(jp JobParams) (interfaces.Job, error) {
 		return nil, fmt.Errorf("Job `%s` init failed. Can't to check `mysqldump` version. Please install `mysqldump`. Error: %s ", jp.Name, err)
 	}
 
+	// `--master-data` is deprecated in favor of `--source-data` since MySQL 8.0.26
+	sourceDataOpt := "--master-data=2"
+	if help, err := exec_cmd.Exec("mysqldump", "--help"); err == nil && strings.Contains(help.Stdout, "--source-data") {
+		sourceDataOpt = "--source-data=2"
+	}
+
+	parallelTargets := jp.ParallelTargets
+	if parallelTargets < 1 {
+		parallelTargets = 1
+	}
+
 	j := &job{
 		name:             jp.Name,
 		tmpDir:           jp.TmpDir,
 		needToMakeBackup: jp.NeedToMakeBackup,
 		safetyBackup:     jp.SafetyBackup,
 		deferredCopying:  jp.DeferredCopying,
+		parallelTargets:  parallelTargets,
 		storages:         jp.Storages,
 		targets:          make(map[string]target),
 		dumpedObjects:    make(map[string]interfaces.DumpObject),
+		sourceDataOpt:    sourceDataOpt,
+		replicaPositions: make(map[string]*binlogPosition),
 	}
 
 	for _, src := range jp.Sources {
 
+		if src.IsSlave {
+			switch src.SlaveChecks.OnFailure {
+			case "":
+				src.SlaveChecks.OnFailure = "fail"
+			case "fail", "skip":
+			default:
+				return nil, fmt.Errorf("Job `%s` init failed. Unknown slave checks failure action \"%s\" of source `%s`. Allowed actions: fail, skip ", jp.Name, src.SlaveChecks.OnFailure, src.Name)
+			}
+		}
+
+		if src.SplitTables {
+			// check if tar available
+			if _, err := exec_cmd.Exec("tar", "--version"); err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Can't check `tar` version. Please install `tar`. Error: %s ", jp.Name, err)
+			}
+		}
+
 		dbConn, authFile, err := mysql_connect.GetConnectAndCnfFile(src.ConnectParams, "mysqldump")
 		if err != nil {
 			return nil, fmt.Errorf("Job `%s` init failed. MySQL connect error: %s ", jp.Name, err)
@@ -104,10 +191,11 @@ func Init(jp JobParams) (interfaces.Job, error) {
 			var ignoreTables []string
 			for _, excl := range src.Excludes {
 				if matched, _ := regexp.MatchString(`^`+db+`\..*$`, excl); matched {
-					ignoreTables = append(ignoreTables, "--ignore-table="+excl)
+					ignoreTables = append(ignoreTables, excl)
 				}
 			}
 			j.targets[src.Name+"/"+db] = target{
+				srcName:      src.Name,
 				connect:      dbConn,
 				authFile:     authFile,
 				dbName:       db,
@@ -115,6 +203,8 @@ func Init(jp JobParams) (interfaces.Job, error) {
 				extraKeys:    src.ExtraKeys,
 				gzip:         src.Gzip,
 				isSlave:      src.IsSlave,
+				slaveChecks:  src.SlaveChecks,
+				splitTables:  src.SplitTables,
 			}
 		}
 	}
@@ -178,28 +268,78 @@ func (j *job) CleanupTmpData() error {
 func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 	var errs *multierror.Error
 
-	for ofsPart, tgt := range j.targets {
-
-		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "sql", "", tgt.gzip)
-		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
-		if err != nil {
-			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+	// replication is stopped once per source for the whole job run,
+	// so that databases of the source dumped in parallel are consistent with each other
+	skippedSources := make(map[string]bool)
+	var startSlaves []func()
+	for _, tgt := range j.getSlaveTargets() {
+		if err := j.checkSlave(tgt); err != nil {
+			skippedSources[tgt.srcName] = true
+			if tgt.slaveChecks.OnFailure == "skip" {
+				logCh <- logger.Log(j.name, "").Warnf("Slave checks of source `%s` failed, its dumps will be skipped. Error: %s", tgt.srcName, err)
+			} else {
+				logCh <- logger.Log(j.name, "").Errorf("Slave checks of source `%s` failed. Error: %s", tgt.srcName, err)
+				errs = multierror.Append(errs, err)
+			}
+			continue
+		}
+		if err := j.stopSlave(logCh, tgt); err != nil {
+			skippedSources[tgt.srcName] = true
 			errs = multierror.Append(errs, err)
 			continue
 		}
+		// the replication is started again even if the program is terminated during the dumps
+		slave := tgt
+		startSlaves = append(startSlaves, misc.OnExit(func() { j.startSlave(logCh, slave) }))
+		j.replicaPositions[tgt.srcName] = j.getStoppedReplicaPosition(logCh, tgt)
+	}
+	defer func() {
+		for _, start := range startSlaves {
+			start()
+		}
+	}()
 
-		if err = j.createTmpBackup(logCh, tmpBackupFile, tgt); err != nil {
-			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
-			errs = multierror.Append(errs, err)
+	resCh := make(chan dumpResult)
+	go func() {
+		defer misc.CleanupOnPanic()
+
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, j.parallelTargets)
+
+		for ofsPart, tgt := range j.targets {
+			if skippedSources[tgt.srcName] {
+				continue
+			}
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(ofsPart string, tgt target) {
+				defer misc.CleanupOnPanic()
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				resCh <- j.dumpTarget(logCh, tmpDir, ofsPart, tgt)
+			}(ofsPart, tgt)
+		}
+
+		wg.Wait()
+		close(resCh)
+	}()
+
+	for res := range resCh {
+		if res.err != nil {
+			errs = multierror.Append(errs, res.err)
 			continue
-		} else {
-			logCh <- logger.Log(j.name, "").Debugf("Created temp backups %s", tmpBackupFile)
 		}
 
-		j.dumpedObjects[ofsPart] = interfaces.DumpObject{TmpFile: tmpBackupFile}
+		dumpObj := interfaces.DumpObject{TmpFile: res.tmpBackupFile}
+		if res.mtdFile != "" {
+			dumpObj.MetaFiles = []string{res.mtdFile}
+		}
+		j.dumpedObjects[res.ofsPart] = dumpObj
 
 		if !j.deferredCopying {
-			if err = j.storages.Delivery(logCh, j); err != nil {
+			if err := j.storages.Delivery(logCh, j); err != nil {
 				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
 				errs = multierror.Append(errs, err)
 			}
@@ -214,42 +354,343 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 	return errs.ErrorOrNil()
 }
 
-func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string, target target) error {
-	var errs *multierror.Error
+func (j *job) dumpTarget(logCh chan logger.LogRecord, tmpDir, ofsPart string, tgt target) (res dumpResult) {
 
-	backupWriter, err := targz.GetFileWriter(tmpBackupFile, target.gzip)
+	res.ofsPart = ofsPart
+
+	if tgt.splitTables {
+		res.tmpBackupFile = misc.GetFileFullPath(tmpDir, ofsPart, "tar", "", tgt.gzip)
+	} else {
+		res.tmpBackupFile = misc.GetFileFullPath(tmpDir, ofsPart, "sql", "", tgt.gzip)
+	}
+
+	if res.err = os.MkdirAll(path.Dir(res.tmpBackupFile), os.ModePerm); res.err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", res.err)
+		return
+	}
+
+	mtd := j.getDumpMetadata(logCh, tgt)
+	if consistencyArgs := j.getConsistencyArgs(logCh, tgt); len(consistencyArgs) > 0 {
+		tgt.extraKeys = append(append([]string{}, tgt.extraKeys...), consistencyArgs...)
+		mtd.SingleTransaction = true
+	}
+
+	if tgt.splitTables {
+		res.err = j.createTmpTablesBackup(logCh, res.tmpBackupFile, tgt)
+	} else {
+		tgt.extraKeys = append(append([]string{}, tgt.extraKeys...), j.getPositionArgs(logCh, tgt, mtd.SingleTransaction)...)
+		res.err = j.createTmpBackup(logCh, res.tmpBackupFile, tgt, &mtd)
+	}
+	if res.err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", res.tmpBackupFile)
+		return
+	}
+
+	logCh <- logger.Log(j.name, "").Debugf("Created temp backups %s", res.tmpBackupFile)
+
+	mtdFile := res.tmpBackupFile + ".meta.json"
+	if err := writeDumpMetadata(mtdFile, mtd); err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to save metadata of `%s` dump. Error: %s", tgt.dbName, err)
+	} else {
+		res.mtdFile = mtdFile
+	}
+
+	return
+}
+
+// getConsistencyArgs returns `--single-transaction` option if all tables of the database use InnoDB engine
+// and the consistency of the dump isn't defined by extra keys
+func (j *job) getConsistencyArgs(logCh chan logger.LogRecord, tgt target) []string {
+	for _, key := range tgt.extraKeys {
+		if matched, _ := regexp.MatchString(`^(--single-transaction|--skip-single-transaction|--lock-all-tables|-x|--lock-tables|-l)$`, key); matched {
+			return nil
+		}
+	}
+
+	var engines []string
+	err := tgt.connect.Select(&engines, "SELECT DISTINCT IFNULL(ENGINE, '') FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_TYPE = 'BASE TABLE'", tgt.dbName)
 	if err != nil {
-		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp file. Error: %s", err)
-		errs = multierror.Append(errs, err)
-		return errs
+		logCh <- logger.Log(j.name, "").Warnf("Unable to check tables engines of `%s`. Error: %s", tgt.dbName, err)
+		return nil
+	}
+
+	var nonInnoDB []string
+	for _, e := range engines {
+		if !strings.EqualFold(e, "InnoDB") {
+			nonInnoDB = append(nonInnoDB, e)
+		}
+	}
+	if len(nonInnoDB) > 0 {
+		logCh <- logger.Log(j.name, "").Infof("Database `%s` contains tables with engines %s. The `--single-transaction` option won't be used", tgt.dbName, strings.Join(nonInnoDB, ", "))
+		return nil
+	}
+
+	logCh <- logger.Log(j.name, "").Debugf("All tables of `%s` use InnoDB. The `--single-transaction` option will be used", tgt.dbName)
+
+	return []string{"--single-transaction"}
+}
+
+// getDumpMetadata reads binary log positions of the server and its replication source with read-only queries.
+// The replication of the slave source is stopped for the whole job run, so its position matches the dump
+func (j *job) getDumpMetadata(logCh chan logger.LogRecord, tgt target) dumpMetadata {
+	mtd := dumpMetadata{
+		Database:      tgt.dbName,
+		CreatedAt:     misc.GetDateTimeNow(""),
+		ReplicaStatus: j.replicaPositions[tgt.srcName],
+	}
+
+	status, err := mysql_connect.GetMasterStatus(tgt.connect)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to get master status. Error: %s", err)
+	} else if len(status) > 0 {
+		mtd.MasterStatus = &binlogPosition{
+			LogFile: getStatusValue(status, "File"),
+			LogPos:  getStatusValue(status, "Position"),
+			GtidSet: getStatusValue(status, "Executed_Gtid_Set"),
+		}
 	}
-	defer func() { _ = backupWriter.Close() }()
 
-	if target.isSlave {
-		_, err = target.connect.Exec("STOP SLAVE")
+	if mtd.ReplicaStatus == nil {
+		mtd.ReplicaStatus, err = getReplicaPosition(tgt)
 		if err != nil {
-			logCh <- logger.Log(j.name, "").Errorf("Unable to stop slave. Error: %s", err)
-			errs = multierror.Append(errs, err)
-			return errs
+			logCh <- logger.Log(j.name, "").Warnf("Unable to get replica status. Error: %s", err)
 		}
-		logCh <- logger.Log(j.name, "").Infof("Slave stopped")
-		defer func() {
-			_, err = target.connect.Exec("START SLAVE")
-			if err != nil {
-				logCh <- logger.Log(j.name, "").Errorf("Unable to start slave. Error: %s", err)
-				errs = multierror.Append(errs, err)
-			} else {
-				logCh <- logger.Log(j.name, "").Infof("Slave started")
+	}
+
+	return mtd
+}
+
+// getPositionArgs returns `--source-data=2` option making mysqldump write the binary log position matching the dump.
+// It's added only for dumps made with `--single-transaction`, since otherwise the option locks all tables for the whole
+// dump, and if the user has `RELOAD` and `REPLICATION CLIENT` privileges required by the option.
+// Nothing is added if the positions options are defined by extra keys
+func (j *job) getPositionArgs(logCh chan logger.LogRecord, tgt target, singleTransaction bool) []string {
+	for _, key := range tgt.extraKeys {
+		if matched, _ := regexp.MatchString(`^--(master-data|source-data|dump-slave|dump-replica)(=.*)?$`, key); matched {
+			return nil
+		}
+	}
+	if !singleTransaction {
+		return nil
+	}
+
+	var logBin bool
+	if err := tgt.connect.Get(&logBin, "SELECT @@GLOBAL.log_bin"); err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to check if binary log is enabled. Error: %s", err)
+		return nil
+	}
+	if !logBin {
+		return nil
+	}
+
+	privs, err := mysql_connect.GetGlobalPrivileges(tgt.connect)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to check privileges of the user. Error: %s", err)
+		return nil
+	}
+	if !misc.Contains(privs, "RELOAD") || !(misc.Contains(privs, "REPLICATION CLIENT") || misc.Contains(privs, "SUPER")) {
+		logCh <- logger.Log(j.name, "").Debugf("The user has no RELOAD and REPLICATION CLIENT privileges, the binary log position of `%s` dump is read before the dump", tgt.dbName)
+		return nil
+	}
+
+	return []string{j.sourceDataOpt}
+}
+
+// getStoppedReplicaPosition returns the position of the replication source executed by the stopped replica
+func (j *job) getStoppedReplicaPosition(logCh chan logger.LogRecord, tgt target) *binlogPosition {
+	pos, err := getReplicaPosition(tgt)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to get replica status of source `%s`. Error: %s", tgt.srcName, err)
+		return nil
+	}
+	if pos != nil {
+		pos.Consistent = true
+	}
+
+	return pos
+}
+
+// getReplicaPosition returns the position of the replication source executed by the replica,
+// nil is returned if the server isn't a replica
+func getReplicaPosition(tgt target) (*binlogPosition, error) {
+	status, err := mysql_connect.GetReplicaStatus(tgt.connect)
+	if err != nil || len(status) == 0 {
+		return nil, err
+	}
+
+	return &binlogPosition{
+		LogFile: getStatusValue(status, "Relay_Source_Log_File", "Relay_Master_Log_File"),
+		LogPos:  getStatusValue(status, "Exec_Source_Log_Pos", "Exec_Master_Log_Pos"),
+		GtidSet: getStatusValue(status, "Executed_Gtid_Set"),
+	}, nil
+}
+
+// parseDumpPositions looks for positions written by `--source-data` and `--dump-replica` options in the dump beginning.
+// These positions match the dump exactly and replace ones read before the dump
+func parseDumpPositions(head []byte) (master, replica *binlogPosition) {
+	var ofReplicaSource bool
+
+	for _, line := range strings.Split(string(head), "\n") {
+		if positionCommentRegex.MatchString(line) {
+			// `--dump-replica` comment ends with `(the master of this slave)` or `(the source of this replica)`
+			ofReplicaSource = strings.HasSuffix(strings.TrimSpace(line), ")")
+			continue
+		}
+		m := changeSourceRegex.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		pos := &binlogPosition{LogFile: m[1], LogPos: m[2], Consistent: true}
+		if ofReplicaSource {
+			replica = pos
+		} else {
+			master = pos
+		}
+	}
+
+	// GTID_PURGED of the dump is the set of transactions executed by the dumped server
+	if m := gtidPurgedRegex.FindSubmatch(head); m != nil {
+		gtidSet := strings.ReplaceAll(string(m[1]), "\n", "")
+		switch {
+		case master != nil:
+			master.GtidSet = gtidSet
+		case replica != nil:
+			replica.GtidSet = gtidSet
+		}
+	}
+
+	return
+}
+
+// headBuffer keeps the first bytes written to it up to the limit
+type headBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if n := h.limit - h.buf.Len(); n > 0 {
+		if len(p) < n {
+			n = len(p)
+		}
+		h.buf.Write(p[:n])
+	}
+	return len(p), nil
+}
+
+func writeDumpMetadata(filePath string, mtd dumpMetadata) error {
+	data, err := json.MarshalIndent(mtd, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// getSlaveTargets returns one target per slave source
+func (j *job) getSlaveTargets() (tgts []target) {
+	sources := make(map[string]bool)
+	for _, tgt := range j.targets {
+		if !tgt.isSlave || sources[tgt.srcName] {
+			continue
+		}
+		sources[tgt.srcName] = true
+		tgts = append(tgts, tgt)
+	}
+	return
+}
+
+// checkSlave checks replication threads state and replication lag of the source
+func (j *job) checkSlave(tgt target) error {
+	if tgt.slaveChecks.MaxLag == 0 && !tgt.slaveChecks.CheckThreads {
+		return nil
+	}
+
+	status, err := mysql_connect.GetReplicaStatus(tgt.connect)
+	if err != nil {
+		return fmt.Errorf("unable to get replica status: %s", err)
+	}
+	if len(status) == 0 {
+		return fmt.Errorf("server isn't a replica")
+	}
+
+	if tgt.slaveChecks.CheckThreads {
+		for _, thread := range []string{"IO", "SQL"} {
+			running := getStatusValue(status, "Replica_"+thread+"_Running", "Slave_"+thread+"_Running")
+			if running != "Yes" {
+				return fmt.Errorf("replication %s thread isn't running (state: `%s`)", thread, running)
 			}
-		}()
+		}
+	}
+
+	if tgt.slaveChecks.MaxLag > 0 {
+		lag, err := strconv.Atoi(getStatusValue(status, "Seconds_Behind_Source", "Seconds_Behind_Master"))
+		if err != nil {
+			return fmt.Errorf("replication lag is unknown")
+		}
+		if lag > tgt.slaveChecks.MaxLag {
+			return fmt.Errorf("replication lag %d seconds exceeds the limit of %d seconds", lag, tgt.slaveChecks.MaxLag)
+		}
+	}
+
+	return nil
+}
+
+func getStatusValue(status map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := status[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func (j *job) stopSlave(logCh chan logger.LogRecord, tgt target) error {
+	if err := execReplicationCmd(tgt, "STOP"); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to stop slave of source `%s`. Error: %s", tgt.srcName, err)
+		return err
+	}
+	logCh <- logger.Log(j.name, "").Infof("Slave of source `%s` stopped", tgt.srcName)
+	return nil
+}
+
+func (j *job) startSlave(logCh chan logger.LogRecord, tgt target) {
+	if err := execReplicationCmd(tgt, "START"); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to start slave of source `%s`. Error: %s", tgt.srcName, err)
+	} else {
+		logCh <- logger.Log(j.name, "").Infof("Slave of source `%s` started", tgt.srcName)
+	}
+}
+
+// execReplicationCmd starts or stops the replication. The `REPLICA` syntax is tried first, `SLAVE` is used for old servers
+func execReplicationCmd(tgt target, action string) (err error) {
+	var thread string
+	if tgt.slaveChecks.StopSQLThreads {
+		thread = " SQL_THREAD"
+	}
+
+	for _, kw := range []string{"REPLICA", "SLAVE"} {
+		if _, err = tgt.connect.Exec(action + " " + kw + thread); err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string, target target, mtd *dumpMetadata) error {
+
+	backupWriter, err := targz.GetFileWriter(tmpBackupFile, target.gzip)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp file. Error: %s", err)
+		return err
 	}
+	defer func() { _ = backupWriter.Close() }()
 
 	var args []string
 	// define command args with auth options
 	args = append(args, "--defaults-file="+target.authFile)
 	// add tables exclude
-	if len(target.ignoreTables) > 0 {
-		args = append(args, target.ignoreTables...)
+	for _, tbl := range target.ignoreTables {
+		args = append(args, "--ignore-table="+tbl)
 	}
 	// add extra dump cmd options
 	if len(target.extraKeys) > 0 {
@@ -258,29 +699,122 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string,
 	// add db name
 	args = append(args, target.dbName)
 
-	var stderr bytes.Buffer
-	cmd := exec.Command("mysqldump", args...)
-	cmd.Stdout = backupWriter
-	cmd.Stderr = &stderr
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` dump", target.dbName)
 
-	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+	head := &headBuffer{limit: dumpHeadSize}
+	if err = j.runDump(logCh, io.MultiWriter(backupWriter, head), args); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to dump `%s`. Error: %s", target.dbName, err)
+		return err
+	}
 
-	if err = cmd.Start(); err != nil {
-		logCh <- logger.Log(j.name, "").Errorf("Unable to start mysqldump. Error: %s", err)
-		errs = multierror.Append(errs, err)
-		return errs
+	master, replica := parseDumpPositions(head.buf.Bytes())
+	if master != nil {
+		mtd.MasterStatus = master
+	}
+	if replica != nil {
+		mtd.ReplicaStatus = replica
 	}
-	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` dump", target.dbName)
 
-	if err = cmd.Wait(); err != nil {
-		logCh <- logger.Log(j.name, "").Errorf("Unable to dump `%s`. Error: %s", target.dbName, stderr.String())
-		errs = multierror.Append(errs, err)
-		return errs
+	logCh <- logger.Log(j.name, "").Infof("Dump of `%s` completed", target.dbName)
+
+	return nil
+}
+
+// createTmpTablesBackup dumps schema and data of each table of database into separate files and packs them into tar
+func (j *job) createTmpTablesBackup(logCh chan logger.LogRecord, tmpBackupFile string, target target) error {
+
+	var tables []struct {
+		Name string `db:"TABLE_NAME"`
+		Type string `db:"TABLE_TYPE"`
+	}
+
+	err := target.connect.Select(&tables, "SELECT TABLE_NAME, TABLE_TYPE FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?", target.dbName)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to list tables of `%s`. Error: %s", target.dbName, err)
+		return err
+	}
+
+	tmpDumpPath := path.Join(path.Dir(tmpBackupFile), "mysql_"+target.dbName+"_"+misc.GetDateTimeNow(""))
+	if err = os.MkdirAll(tmpDumpPath, os.ModePerm); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmpDumpPath) }()
+
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` dump by tables", target.dbName)
+
+	baseArgs := []string{"--defaults-file=" + target.authFile}
+	baseArgs = append(baseArgs, target.extraKeys...)
+
+	// routines and events are dumped once for the whole database
+	args := append(append([]string{}, baseArgs...), "--no-create-info", "--no-data", "--no-create-db", "--skip-triggers", "--routines", "--events", target.dbName)
+	if err = j.dumpToFile(logCh, path.Join(tmpDumpPath, "_routines.sql"), args); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to dump routines of `%s`. Error: %s", target.dbName, err)
+		return err
+	}
+
+	for _, tbl := range tables {
+		if misc.Contains(target.ignoreTables, target.dbName+"."+tbl.Name) {
+			continue
+		}
+
+		args = append(append([]string{}, baseArgs...), "--no-data", "--skip-routines", "--skip-events", target.dbName, tbl.Name)
+		if err = j.dumpToFile(logCh, path.Join(tmpDumpPath, tbl.Name+".schema.sql"), args); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to dump schema of `%s.%s`. Error: %s", target.dbName, tbl.Name, err)
+			return err
+		}
+
+		if tbl.Type != "BASE TABLE" {
+			continue
+		}
+
+		args = append(append([]string{}, baseArgs...), "--no-create-info", "--skip-triggers", "--skip-routines", "--skip-events", target.dbName, tbl.Name)
+		if err = j.dumpToFile(logCh, path.Join(tmpDumpPath, tbl.Name+".data.sql"), args); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to dump data of `%s.%s`. Error: %s", target.dbName, tbl.Name, err)
+			return err
+		}
+	}
+
+	if err = targz.Tar(tmpDumpPath, tmpBackupFile, false, target.gzip, false, nil); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
+		if serr, ok := err.(targz.Error); ok {
+			logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", serr.Stderr)
+		}
+		return err
 	}
 
 	logCh <- logger.Log(j.name, "").Infof("Dump of `%s` completed", target.dbName)
 
-	return errs.ErrorOrNil()
+	return nil
+}
+
+func (j *job) dumpToFile(logCh chan logger.LogRecord, filePath string, args []string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	return j.runDump(logCh, file, args)
+}
+
+func (j *job) runDump(logCh chan logger.LogRecord, out io.Writer, args []string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("mysqldump", args...)
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
+
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s: %s", err, stderr.String())
+		}
+		return err
+	}
+
+	return nil
 }
 
 func (j *job) Close() error {
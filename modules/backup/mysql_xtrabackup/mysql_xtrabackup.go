@@ -1,14 +1,19 @@
 package mysql_xtrabackup
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
-	"github.com/hashicorp/go-multierror"
+	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
 
+	"github.com/hashicorp/go-multierror"
+
 	"nxs-backup/interfaces"
 	"nxs-backup/misc"
 	"nxs-backup/modules/backend/exec_cmd"
@@ -23,18 +28,22 @@ type job struct {
 	needToMakeBackup bool
 	safetyBackup     bool
 	deferredCopying  bool
+	incremental      bool
 	storages         interfaces.Storages
 	targets          map[string]target
 	dumpedObjects    map[string]interfaces.DumpObject
 }
 
 type target struct {
+	binary          string
 	extraKeys       []string
 	authFile        string
 	ignoreDatabases string
+	encryptKeyFile  string
 	gzip            bool
 	isSlave         bool
 	prepare         bool
+	stream          bool
 }
 
 type JobParams struct {
@@ -43,6 +52,7 @@ type JobParams struct {
 	NeedToMakeBackup bool
 	SafetyBackup     bool
 	DeferredCopying  bool
+	Incremental      bool
 	Storages         interfaces.Storages
 	Sources          []SourceParams
 }
@@ -56,14 +66,13 @@ type SourceParams struct {
 	Gzip          bool
 	IsSlave       bool
 	Prepare       bool
+	Stream        bool   // Stream backup in xbstream format instead of copying to the directory
+	Binary        string // Backup tool binary: `xtrabackup` or `mariabackup`
+	EncryptKey    string // Path to the encryption key file
 }
 
 func Init(jp JobParams) (interfaces.Job, error) {
 
-	// check if xtrabackup available
-	if _, err := exec_cmd.Exec("xtrabackup", "--version"); err != nil {
-		return nil, fmt.Errorf("Job `%s` init failed. Can't to check `xtrabackup` version. Please install `xtrabackup`. Error: %s ", jp.Name, err)
-	}
 	// check if tar and gzip available
 	if _, err := exec_cmd.Exec("tar", "--version"); err != nil {
 		return nil, fmt.Errorf("Job `%s` init failed. Can't check `tar` version. Please install `tar`. Error: %s ", jp.Name, err)
@@ -75,6 +84,7 @@ func Init(jp JobParams) (interfaces.Job, error) {
 		needToMakeBackup: jp.NeedToMakeBackup,
 		safetyBackup:     jp.SafetyBackup,
 		deferredCopying:  jp.DeferredCopying,
+		incremental:      jp.Incremental,
 		storages:         jp.Storages,
 		targets:          make(map[string]target),
 		dumpedObjects:    make(map[string]interfaces.DumpObject),
@@ -82,6 +92,32 @@ func Init(jp JobParams) (interfaces.Job, error) {
 
 	for _, src := range jp.Sources {
 
+		if jp.Incremental && src.Prepare {
+			return nil, fmt.Errorf("Job `%s` init failed. Incremental backups can't be prepared, please disable `prepare_xtrabackup` option of source `%s` ", jp.Name, src.Name)
+		}
+		if src.Stream && src.Prepare {
+			return nil, fmt.Errorf("Job `%s` init failed. Streamed backups can't be prepared, please disable `prepare_xtrabackup` option of source `%s` ", jp.Name, src.Name)
+		}
+		// encrypted files must be decrypted before the prepare, so the prepared backup would be stored unencrypted
+		if src.EncryptKey != "" && src.Prepare {
+			return nil, fmt.Errorf("Job `%s` init failed. Encrypted backups can't be prepared, please disable `prepare_xtrabackup` option of source `%s` ", jp.Name, src.Name)
+		}
+
+		binary := src.Binary
+		if binary == "" {
+			binary = "xtrabackup"
+		}
+		if !misc.Contains([]string{"xtrabackup", "mariabackup"}, path.Base(binary)) {
+			return nil, fmt.Errorf("Job `%s` init failed. Unsupported backup tool `%s` of source `%s`. Allowed tools: xtrabackup, mariabackup ", jp.Name, binary, src.Name)
+		}
+		if src.EncryptKey != "" && path.Base(binary) == "mariabackup" {
+			return nil, fmt.Errorf("Job `%s` init failed. Encryption isn't supported by `mariabackup`, source `%s` ", jp.Name, src.Name)
+		}
+		// check if backup tool available
+		if _, err := exec_cmd.Exec(binary, "--version"); err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. Can't to check `%s` version. Please install `%s`. Error: %s ", jp.Name, binary, binary, err)
+		}
+
 		_, authFile, err := mysql_connect.GetConnectAndCnfFile(src.ConnectParams, "xtrabackup")
 		if err != nil {
 			return nil, err
@@ -97,12 +133,15 @@ func Init(jp JobParams) (interfaces.Job, error) {
 		ignoreDBs = strings.TrimSuffix(ignoreDBs, " ")
 
 		j.targets[src.Name] = target{
+			binary:          binary,
 			authFile:        authFile,
 			ignoreDatabases: ignoreDBs,
+			encryptKeyFile:  src.EncryptKey,
 			extraKeys:       src.ExtraKeys,
 			gzip:            src.Gzip,
 			isSlave:         src.IsSlave,
 			prepare:         src.Prepare,
+			stream:          src.Stream,
 		}
 	}
 
@@ -147,11 +186,11 @@ func (j *job) IsBackupSafety() bool {
 }
 
 func (j *job) NeedToMakeBackup() bool {
-	return j.needToMakeBackup
+	return j.incremental || j.needToMakeBackup
 }
 
 func (j *job) NeedToUpdateIncMeta() bool {
-	return false
+	return j.incremental
 }
 
 func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
@@ -167,7 +206,11 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 
 	for ofsPart, tgt := range j.targets {
 
-		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "tar", "", tgt.gzip)
+		ext := "tar"
+		if tgt.stream {
+			ext = "xbstream"
+		}
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, ext, "", tgt.gzip)
 		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
 		if err != nil {
 			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
@@ -175,7 +218,28 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 			continue
 		}
 
-		if err = j.createTmpBackup(logCh, tmpBackupFile, ofsPart, tgt); err != nil {
+		var baseLSN string
+		if j.incremental {
+			var initChain bool
+			initChain, baseLSN, err = j.getBaseLSN(logCh, ofsPart)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+
+			if initChain {
+				logCh <- logger.Log(j.name, "").Info("Incremental backup will be reinitialized.")
+
+				if err = j.DeleteOldBackups(logCh, ofsPart); err != nil {
+					errs = multierror.Append(errs, err)
+				}
+				if _, err = os.Create(tmpBackupFile + ".init"); err != nil {
+					errs = multierror.Append(errs, err)
+				}
+			}
+		}
+
+		if err = j.createTmpBackup(logCh, tmpBackupFile, ofsPart, baseLSN, tgt); err != nil {
 			logCh <- logger.Log(j.name, "").Errorf("Failed to create temp backups %s", tmpBackupFile)
 			errs = multierror.Append(errs, err)
 			continue
@@ -201,7 +265,7 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 	return errs.ErrorOrNil()
 }
 
-func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtName string, target target) error {
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtName, baseLSN string, target target) error {
 
 	var (
 		stderr, stdout          bytes.Buffer
@@ -221,16 +285,41 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtNam
 	if target.isSlave {
 		backupArgs = append(backupArgs, "--safe-slave-backup")
 	}
+	if baseLSN != "" {
+		backupArgs = append(backupArgs, "--incremental-lsn="+baseLSN)
+	}
+	if target.encryptKeyFile != "" {
+		backupArgs = append(backupArgs, "--encrypt=AES256", "--encrypt-key-file="+target.encryptKeyFile)
+	}
+	if target.stream {
+		// in stream mode the target dir is used for temporary files only, checkpoints are saved to the same dir
+		backupArgs = append(backupArgs, "--stream=xbstream", "--extra-lsndir="+tmpXtrabackupPath)
+		if err := os.MkdirAll(tmpXtrabackupPath, os.ModePerm); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			return err
+		}
+		defer func() { _ = os.RemoveAll(tmpXtrabackupPath) }()
+	}
 	// add extra backup options
 	if len(target.extraKeys) > 0 {
 		backupArgs = append(backupArgs, target.extraKeys...)
 	}
 
-	cmd := exec.Command("xtrabackup", backupArgs...)
-	cmd.Stdout = &stdout
+	cmd := exec.Command(target.binary, backupArgs...)
 	cmd.Stderr = &stderr
+	if target.stream {
+		backupWriter, err := targz.GetFileWriter(tmpBackupFile, target.gzip)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp file. Error: %s", err)
+			return err
+		}
+		defer func() { _ = backupWriter.Close() }()
+		cmd.Stdout = backupWriter
+	} else {
+		cmd.Stdout = &stdout
+	}
 
-	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
 
 	if err := cmd.Start(); err != nil {
 		logCh <- logger.Log(j.name, "").Errorf("Unable to start xtrabackup. Error: %s", err)
@@ -253,13 +342,24 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtNam
 		return err
 	}
 
+	if target.stream {
+		if j.incremental {
+			if err := copyFile(path.Join(tmpXtrabackupPath, "xtrabackup_checkpoints"), tmpBackupFile+".inc"); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Unable to save xtrabackup checkpoints: %s", err)
+				return err
+			}
+		}
+		logCh <- logger.Log(j.name, "").Infof("Dump of `%s` completed", tgtName)
+		return nil
+	}
+
 	stdout.Reset()
 	stderr.Reset()
 
 	if target.prepare {
 		// add prepare options
 		prepareArgs = append(prepareArgs, "--prepare", "--target-dir="+tmpXtrabackupPath)
-		cmd = exec.Command("xtrabackup", prepareArgs...)
+		cmd = exec.Command(target.binary, prepareArgs...)
 		cmd.Stdout = &stdout
 		cmd.Stderr = &stderr
 
@@ -279,6 +379,14 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtNam
 		}
 	}
 
+	if j.incremental {
+		// checkpoints of the backup are stored as incremental metadata
+		if err := copyFile(path.Join(tmpXtrabackupPath, "xtrabackup_checkpoints"), tmpBackupFile+".inc"); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to save xtrabackup checkpoints: %s", err)
+			return err
+		}
+	}
+
 	if err := targz.Tar(tmpXtrabackupPath, tmpBackupFile, false, target.gzip, false, nil); err != nil {
 		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
 		if serr, ok := err.(targz.Error); ok {
@@ -293,6 +401,106 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtNam
 	return nil
 }
 
+// getBaseLSN returns LSN of the backup the new incremental backup will be based on.
+// Yearly backup is full. Monthly backups are based on the yearly one, decade backups are based on the monthly ones
+// and daily backups are based on the decade ones, so removing of outdated months never breaks a chain
+func (j *job) getBaseLSN(logCh chan logger.LogRecord, ofsPart string) (initChain bool, lsn string, err error) {
+	var mtdFile io.Reader
+
+	dom := misc.GetDateTimeNow("dom")
+
+	if misc.GetDateTimeNow("doy") == misc.YearlyBackupDay {
+		return true, "", nil
+	}
+
+	mtdFile, err = j.getMetadataFile(logCh, ofsPart, "year.inc")
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Failed to find backup year metadata. Error: %v", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return true, "", err
+	}
+
+	var baseMtd string
+	if dom == misc.MonthlyBackupDay {
+		baseMtd = "year.inc"
+	} else if misc.Contains(misc.DecadesBackupDays, dom) {
+		baseMtd = "month.inc"
+	} else {
+		baseMtd = "day.inc"
+	}
+
+	if baseMtd != "year.inc" {
+		mtdFile, err = j.getMetadataFile(logCh, ofsPart, baseMtd)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Failed to find backup `%s` metadata.", baseMtd)
+			return
+		}
+	}
+
+	lsn, err = getToLSN(mtdFile)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to read `%s` metadata. Error: %v", baseMtd, err)
+	}
+
+	return
+}
+
+// check and get metadata files (include remote storages)
+func (j *job) getMetadataFile(logCh chan logger.LogRecord, ofsPart, metadata string) (reader io.Reader, err error) {
+	year := misc.GetDateTimeNow("year")
+
+	for i := len(j.storages) - 1; i >= 0; i-- {
+		st := j.storages[i]
+
+		reader, err = st.GetFileReader(path.Join(ofsPart, year, "inc_meta_info", metadata))
+		if err != nil {
+			logCh <- logger.Log(j.name, st.GetName()).Warnf("Unable to get previous metadata '%s' from storage. Error: %s ", metadata, err)
+			continue
+		}
+		break
+	}
+
+	if reader == nil {
+		err = fs.ErrNotExist
+	}
+
+	return
+}
+
+// getToLSN reads the last LSN of the backup from `xtrabackup_checkpoints` file content
+func getToLSN(checkpoints io.Reader) (string, error) {
+	scanner := bufio.NewScanner(checkpoints)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "to_lsn" {
+			return strings.TrimSpace(kv[1]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("`to_lsn` not found in xtrabackup checkpoints")
+}
+
+func copyFile(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = srcFile.Close() }()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dstFile.Close() }()
+
+	_, err = io.Copy(dstFile, srcFile)
+	return err
+}
+
 func xtrabackupStatusErr(out string) error {
 	return fmt.Errorf("xtrabackup finished not success. Please check result:\n%s", out)
 }
@@ -0,0 +1,134 @@
+package mysql_xtrabackup
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/pgzip"
+
+	"nxs-backup/modules/backend/exec_cmd"
+)
+
+// Restore unpacks the full backup and its increments into the target directory and prepares it.
+// Archives must be passed in the order of the chain: yearly, monthly, decade and daily backups.
+// Both tar and xbstream archives are supported, encrypted backups are decrypted with the key file if it is set
+func Restore(targetDir string, archives []string, binary, encryptKeyFile string) error {
+
+	if binary == "" {
+		binary = "xtrabackup"
+	}
+
+	if len(archives) == 0 {
+		return fmt.Errorf("no backups to restore")
+	}
+	if _, err := os.Stat(targetDir); err == nil {
+		return fmt.Errorf("target directory `%s` already exists", targetDir)
+	}
+
+	tmpDir, err := os.MkdirTemp(path.Dir(path.Clean(targetDir)), "xtrabackup_restore_")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	var backupDirs []string
+	for i, archive := range archives {
+		dir := path.Join(tmpDir, strconv.Itoa(i))
+		if err = os.Mkdir(dir, 0700); err != nil {
+			return err
+		}
+
+		backupDir := dir
+		if isStreamArchive(archive) {
+			if err = extractStream(archive, dir, binary); err != nil {
+				return fmt.Errorf("unable to unpack `%s`: %s", archive, err)
+			}
+		} else {
+			if res, err := exec_cmd.Exec("tar", "--extract", "--file="+archive, "--directory="+dir); err != nil {
+				return fmt.Errorf("unable to unpack `%s`: %s %s", archive, err, res.Stderr)
+			}
+
+			// each tar archive contains a single directory with the backup
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+			if len(entries) != 1 || !entries[0].IsDir() {
+				return fmt.Errorf("unexpected content of `%s` archive", archive)
+			}
+			backupDir = path.Join(dir, entries[0].Name())
+		}
+
+		if encryptKeyFile != "" {
+			res, err := exec_cmd.Exec(binary, "--decrypt=AES256", "--encrypt-key-file="+encryptKeyFile, "--remove-original", "--target-dir="+backupDir)
+			if err != nil {
+				return fmt.Errorf("unable to decrypt `%s`: %s\n%s", archive, err, res.Stderr)
+			}
+		}
+
+		backupDirs = append(backupDirs, backupDir)
+	}
+
+	baseDir := backupDirs[0]
+	for i, dir := range backupDirs {
+		args := []string{"--prepare", "--target-dir=" + baseDir}
+		// all prepares except the last one must skip rollback of uncommitted transactions
+		if i < len(backupDirs)-1 {
+			args = append(args, "--apply-log-only")
+		}
+		if i > 0 {
+			args = append(args, "--incremental-dir="+dir)
+		}
+
+		if res, err := exec_cmd.Exec(binary, args...); err != nil {
+			return fmt.Errorf("unable to prepare `%s`: %s\n%s", archives[i], err, res.Stderr)
+		}
+	}
+
+	return os.Rename(baseDir, targetDir)
+}
+
+func isStreamArchive(archive string) bool {
+	return strings.HasSuffix(archive, ".xbstream") || strings.HasSuffix(archive, ".xbstream.gz")
+}
+
+// extractStream unpacks xbstream archive with `xbstream` (or `mbstream` for mariabackup) utility
+func extractStream(archive, dir, binary string) error {
+	var stderr bytes.Buffer
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	var reader io.Reader = file
+	if strings.HasSuffix(archive, ".gz") {
+		gzReader, err := pgzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = gzReader.Close() }()
+		reader = gzReader
+	}
+
+	extractor := "xbstream"
+	if path.Base(binary) == "mariabackup" {
+		extractor = "mbstream"
+	}
+
+	cmd := exec.Command(extractor, "-x", "-C", dir)
+	cmd.Stdin = reader
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s", err, stderr.String())
+	}
+
+	return nil
+}
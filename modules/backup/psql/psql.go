@@ -37,6 +37,10 @@ type target struct {
 	dbName       string
 	ignoreTables []string
 	extraKeys    []string
+	format       string
+	parallelJobs int
+	isSlave      bool
+	slaveChecks  SlaveChecks
 	gzip         bool
 }
 
@@ -56,10 +60,27 @@ type SourceParams struct {
 	TargetDBs     []string
 	Excludes      []string
 	ExtraKeys     []string
+	Format        string
+	ParallelJobs  int
 	Gzip          bool
 	IsSlave       bool
+	SlaveChecks   SlaveChecks
 }
 
+// SlaveChecks defines checks of the replica state made before dumping
+type SlaveChecks struct {
+	MaxLag       int    // Maximum replication lag in seconds, 0 disables the check
+	CheckThreads bool   // Check if WAL receiver is streaming
+	OnFailure    string // Action on failed check: `fail` or `skip`
+}
+
+// allowed pg_dump output formats
+const (
+	formatPlain     = "plain"
+	formatCustom    = "custom"
+	formatDirectory = "directory"
+)
+
 func Init(jp JobParams) (interfaces.Job, error) {
 
 	// check if mysqldump available
@@ -81,10 +102,40 @@ func Init(jp JobParams) (interfaces.Job, error) {
 
 	for _, src := range jp.Sources {
 
+		format := src.Format
+		if format == "" {
+			format = formatPlain
+		}
+		if !misc.Contains([]string{formatPlain, formatCustom, formatDirectory}, format) {
+			return nil, fmt.Errorf("Job `%s` init failed. Unknown format \"%s\" of source `%s`. Allowed formats: %s, %s, %s ", jp.Name, format, src.Name, formatPlain, formatCustom, formatDirectory)
+		}
+		if src.ParallelJobs > 1 && format != formatDirectory {
+			return nil, fmt.Errorf("Job `%s` init failed. Parallel jobs of source `%s` are supported only with `%s` format ", jp.Name, src.Name, formatDirectory)
+		}
+		if format == formatDirectory {
+			// check if tar available
+			if _, err = exec_cmd.Exec("tar", "--version"); err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Can't check `tar` version. Please install `tar`. Error: %s ", jp.Name, err)
+			}
+		}
+
+		if src.IsSlave {
+			switch src.SlaveChecks.OnFailure {
+			case "":
+				src.SlaveChecks.OnFailure = "fail"
+			case "fail", "skip":
+			default:
+				return nil, fmt.Errorf("Job `%s` init failed. Unknown slave checks failure action \"%s\" of source `%s`. Allowed actions: fail, skip ", jp.Name, src.SlaveChecks.OnFailure, src.Name)
+			}
+		}
+
 		for _, key := range src.ExtraKeys {
 			if matched, _ := regexp.MatchString(`(-f|--file)`, key); matched {
 				return nil, fmt.Errorf("Job `%s` init failed. Forbidden usage \"--file|-f\" parameter as extra_keys for `postgresql` jobs type ", jp.Name)
 			}
+			if matched, _ := regexp.MatchString(`^(-F|--format|-j|--jobs)`, key); matched && format != formatPlain {
+				return nil, fmt.Errorf("Job `%s` init failed. Forbidden usage \"--format|-F|--jobs|-j\" parameters as extra_keys together with `format` option ", jp.Name)
+			}
 		}
 
 		// fetch databases list to make backup
@@ -143,6 +194,10 @@ func Init(jp JobParams) (interfaces.Job, error) {
 				dbName:       db,
 				ignoreTables: ignoreTables,
 				extraKeys:    src.ExtraKeys,
+				format:       format,
+				parallelJobs: src.ParallelJobs,
+				isSlave:      src.IsSlave,
+				slaveChecks:  src.SlaveChecks,
 				gzip:         src.Gzip,
 			}
 		}
@@ -209,7 +264,19 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 
 	for ofsPart, tgt := range j.targets {
 
-		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "sql", "", tgt.gzip)
+		if tgt.isSlave {
+			if err := checkSlave(tgt); err != nil {
+				if tgt.slaveChecks.OnFailure == "skip" {
+					logCh <- logger.Log(j.name, "").Warnf("Slave checks for `%s` failed, the dump will be skipped. Error: %s", tgt.dbName, err)
+				} else {
+					logCh <- logger.Log(j.name, "").Errorf("Slave checks for `%s` failed. Error: %s", tgt.dbName, err)
+					errs = multierror.Append(errs, err)
+				}
+				continue
+			}
+		}
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, getFileExtension(tgt.format), "", tgt.gzip)
 		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
 		if err != nil {
 			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
@@ -245,6 +312,10 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 
 func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupPath string, target target) error {
 
+	if target.format == formatDirectory {
+		return j.createTmpDirBackup(logCh, tmpBackupPath, target)
+	}
+
 	backupWriter, err := targz.GetFileWriter(tmpBackupPath, target.gzip)
 	if err != nil {
 		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp file. Error: %s", err)
@@ -252,24 +323,15 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupPath string,
 	}
 	defer func() { _ = backupWriter.Close() }()
 
-	var args []string
-	// define command args
-	// add tables exclude
-	for _, ex := range target.ignoreTables {
-		args = append(args, "--exclude-table="+ex)
-	}
-	// add extra dump cmd options
-	if len(target.extraKeys) > 0 {
-		args = append(args, target.extraKeys...)
-	}
-	args = append(args, "--dbname="+target.connUrl.String())
+	args := getDumpArgs(target)
 
 	var stderr bytes.Buffer
 	cmd := exec.Command("pg_dump", args...)
+	_, cmd.Env = psql_connect.GetCmdConnUrlAndEnv(target.connUrl)
 	cmd.Stdout = backupWriter
 	cmd.Stderr = &stderr
 
-	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
 
 	if err = cmd.Start(); err != nil {
 		logCh <- logger.Log(j.name, "").Errorf("Unable to start pd_dump. Error: %s", err)
@@ -287,6 +349,134 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupPath string,
 	return nil
 }
 
+// createTmpDirBackup makes a dump in directory format and packs it into tar
+func (j *job) createTmpDirBackup(logCh chan logger.LogRecord, tmpBackupPath string, target target) error {
+
+	tmpDumpPath := path.Join(path.Dir(tmpBackupPath), "pg_dump_"+target.dbName+"_"+misc.GetDateTimeNow(""))
+
+	args := getDumpArgs(target)
+	args = append(args, "--file="+tmpDumpPath)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("pg_dump", args...)
+	_, cmd.Env = psql_connect.GetCmdConnUrlAndEnv(target.connUrl)
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
+
+	if err := cmd.Start(); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to start pd_dump. Error: %s", err)
+		return err
+	}
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` dump", target.dbName)
+
+	if err := cmd.Wait(); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to dump `%s`. Error: %s", target.dbName, stderr.String())
+		_ = os.RemoveAll(tmpDumpPath)
+		return err
+	}
+
+	if err := targz.Tar(tmpDumpPath, tmpBackupPath, false, target.gzip, false, nil); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
+		if serr, ok := err.(targz.Error); ok {
+			logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", serr.Stderr)
+		}
+		_ = os.RemoveAll(tmpDumpPath)
+		return err
+	}
+	_ = os.RemoveAll(tmpDumpPath)
+
+	logCh <- logger.Log(j.name, "").Infof("Dump of `%s` completed", target.dbName)
+
+	return nil
+}
+
+// checkSlave checks WAL receiver state and replication lag of the replica
+func checkSlave(tgt target) error {
+	if tgt.slaveChecks.MaxLag == 0 && !tgt.slaveChecks.CheckThreads {
+		return nil
+	}
+
+	dbConn, err := psql_connect.GetConnect(tgt.connUrl)
+	if err != nil {
+		return fmt.Errorf("unable to connect: %s", err)
+	}
+	defer func() { _ = dbConn.Close() }()
+
+	var inRecovery bool
+	if err = dbConn.Get(&inRecovery, "SELECT pg_is_in_recovery()"); err != nil {
+		return fmt.Errorf("unable to get recovery state: %s", err)
+	}
+	if !inRecovery {
+		return fmt.Errorf("server isn't a replica")
+	}
+
+	if tgt.slaveChecks.CheckThreads {
+		var streaming int
+		if err = dbConn.Get(&streaming, "SELECT count(*) FROM pg_stat_wal_receiver WHERE status = 'streaming'"); err != nil {
+			return fmt.Errorf("unable to get WAL receiver state: %s", err)
+		}
+		if streaming == 0 {
+			return fmt.Errorf("WAL receiver isn't streaming")
+		}
+	}
+
+	if tgt.slaveChecks.MaxLag > 0 {
+		// the replica which has replayed all received WAL isn't lagging even if there were no writes on primary for a long time
+		var lag float64
+		err = dbConn.Get(&lag, `SELECT CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), -1)
+		END`)
+		if err != nil {
+			return fmt.Errorf("unable to get replication lag: %s", err)
+		}
+		if lag < 0 {
+			return fmt.Errorf("replication lag is unknown")
+		}
+		if int(lag) > tgt.slaveChecks.MaxLag {
+			return fmt.Errorf("replication lag %d seconds exceeds the limit of %d seconds", int(lag), tgt.slaveChecks.MaxLag)
+		}
+	}
+
+	return nil
+}
+
+func getDumpArgs(target target) []string {
+	var args []string
+	// define command args
+	// set output format
+	if target.format != formatPlain {
+		args = append(args, "--format="+target.format)
+	}
+	if target.parallelJobs > 1 {
+		args = append(args, fmt.Sprintf("--jobs=%d", target.parallelJobs))
+	}
+	// add tables exclude
+	for _, ex := range target.ignoreTables {
+		args = append(args, "--exclude-table="+ex)
+	}
+	// add extra dump cmd options
+	if len(target.extraKeys) > 0 {
+		args = append(args, target.extraKeys...)
+	}
+	dsn, _ := psql_connect.GetCmdConnUrlAndEnv(target.connUrl)
+	args = append(args, "--dbname="+dsn)
+
+	return args
+}
+
+func getFileExtension(format string) string {
+	switch format {
+	case formatCustom:
+		return "dump"
+	case formatDirectory:
+		return "tar"
+	default:
+		return "sql"
+	}
+}
+
 func (j *job) Close() error {
 	for _, st := range j.storages {
 		_ = st.Close()
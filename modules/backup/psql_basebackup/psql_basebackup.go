@@ -208,16 +208,18 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtNam
 	if len(tgt.extraKeys) > 0 {
 		args = append(args, tgt.extraKeys...)
 	}
-	// add db connect
-	args = append(args, "--dbname="+tgt.connUrl.String())
+	// add db connect, the password is passed via environment
+	dsn, env := psql_connect.GetCmdConnUrlAndEnv(tgt.connUrl)
+	args = append(args, "--dbname="+dsn)
 	// add data catalog path
 	args = append(args, "--pgdata="+tmpBasebackupPath)
 
 	cmd := exec.Command("pg_basebackup", args...)
+	cmd.Env = env
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
 
 	if err := cmd.Start(); err != nil {
 		logCh <- logger.Log(j.name, "").Errorf("Unable to start pg_basebackup. Error: %s", err)
@@ -2,7 +2,9 @@ package redis
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path"
@@ -30,8 +32,21 @@ type job struct {
 }
 
 type target struct {
-	dsn  string
-	gzip bool
+	connParams    redis_connect.Params
+	sentinel      bool
+	cluster       bool
+	preferReplica bool
+	gzip          bool
+}
+
+// clusterManifest describes the content of cluster backup archive
+type clusterManifest struct {
+	Shards []clusterManifestShard `json:"shards"`
+}
+
+type clusterManifestShard struct {
+	redis_connect.ClusterShard
+	File string `json:"file"`
 }
 
 type JobParams struct {
@@ -48,6 +63,8 @@ type SourceParams struct {
 	Name          string
 	ConnectParams redis_connect.Params
 	Gzip          bool
+	Cluster       bool // Whether the source is a Redis Cluster
+	PreferReplica bool // Whether to dump replica discovered via Sentinel instead of master
 }
 
 func Init(jp JobParams) (interfaces.Job, error) {
@@ -71,15 +88,37 @@ func Init(jp JobParams) (interfaces.Job, error) {
 
 	for _, src := range jp.Sources {
 
-		conn, dsn, err := redis_connect.GetConnectAndDSN(src.ConnectParams)
-		if err != nil {
-			return nil, fmt.Errorf("Job `%s` init failed. Redis connect error: %s ", jp.Name, err)
+		sentinel := len(src.ConnectParams.SentinelAddrs) > 0
+		if sentinel && src.Cluster {
+			return nil, fmt.Errorf("Job `%s` init failed. Sentinel can't be used with cluster, source `%s` ", jp.Name, src.Name)
+		}
+		if sentinel && src.ConnectParams.SentinelMaster == "" {
+			return nil, fmt.Errorf("Job `%s` init failed. Sentinel master name isn't set for source `%s` ", jp.Name, src.Name)
+		}
+
+		switch {
+		case sentinel:
+			if _, err = redis_connect.GetSentinelNode(src.ConnectParams, src.PreferReplica); err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Redis Sentinel error: %s ", jp.Name, err)
+			}
+		case src.Cluster:
+			if _, err = redis_connect.GetClusterShards(src.ConnectParams); err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to get Redis Cluster slots. Error: %s ", jp.Name, err)
+			}
+		default:
+			conn, err := redis_connect.GetConnect(src.ConnectParams, "")
+			if err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Redis connect error: %s ", jp.Name, err)
+			}
+			_ = conn.Close()
 		}
-		_ = conn.Close()
 
 		j.targets[src.Name] = target{
-			gzip: src.Gzip,
-			dsn:  dsn,
+			connParams:    src.ConnectParams,
+			sentinel:      sentinel,
+			cluster:       src.Cluster,
+			preferReplica: src.PreferReplica,
+			gzip:          src.Gzip,
 		}
 	}
 
@@ -143,7 +182,11 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 	var errs *multierror.Error
 
 	for ofsPart, tgt := range j.targets {
-		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "rdb", "", tgt.gzip)
+		ext := "rdb"
+		if tgt.cluster {
+			ext = "tar"
+		}
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, ext, "", tgt.gzip)
 		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
 		if err != nil {
 			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
@@ -151,7 +194,12 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 			continue
 		}
 
-		if err = j.createTmpBackup(logCh, tmpBackupFile, ofsPart, tgt); err != nil {
+		if tgt.cluster {
+			err = j.createTmpClusterBackup(logCh, tmpBackupFile, ofsPart, tgt)
+		} else {
+			err = j.createTmpBackup(logCh, tmpBackupFile, ofsPart, tgt)
+		}
+		if err != nil {
 			logCh <- logger.Log(j.name, "").Error("Failed to create temp backup.")
 			errs = multierror.Append(errs, err)
 			continue
@@ -175,31 +223,24 @@ func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
 
 func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtName string, tgt target) error {
 
-	var stderr, stdout bytes.Buffer
-
 	tmpBackupRdb := strings.TrimSuffix(tmpBackupFile, ".gz")
 
-	var args []string
-	// define command args
-	// add db connect
-	args = append(args, "-u", tgt.dsn)
-	// add data catalog path
-	args = append(args, "--rdb", tmpBackupRdb)
-
-	cmd := exec.Command("redis-cli", args...)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
-
-	if err := cmd.Start(); err != nil {
-		logCh <- logger.Log(j.name, "").Errorf("Unable to start redis-cli. Error: %s", err)
-		return err
+	// node address is discovered on each run, since master and replicas may change after failover
+	var nodeAddr string
+	if tgt.sentinel {
+		addr, err := redis_connect.GetSentinelNode(tgt.connParams, tgt.preferReplica)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to discover node of `%s` via Sentinel. Error: %s", tgtName, err)
+			return err
+		}
+		logCh <- logger.Log(j.name, "").Infof("Node `%s` discovered via Sentinel", addr)
+		nodeAddr = addr
 	}
+
 	logCh <- logger.Log(j.name, "").Infof("Starting to dump `%s` source", tgtName)
 
-	if err := cmd.Wait(); err != nil {
-		logCh <- logger.Log(j.name, "").Errorf("Unable to make dump `%s`. Error: %s", tgtName, stderr.String())
+	if err := j.dumpRdb(logCh, tmpBackupRdb, nodeAddr, tgt); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make dump `%s`. Error: %s", tgtName, err)
 		return err
 	}
 
@@ -219,6 +260,114 @@ func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtNam
 	return nil
 }
 
+// createTmpClusterBackup fetches RDB of each master shard of the cluster and packs them into tar together with
+// the manifest of slot ranges
+func (j *job) createTmpClusterBackup(logCh chan logger.LogRecord, tmpBackupFile, tgtName string, tgt target) error {
+
+	shards, err := redis_connect.GetClusterShards(tgt.connParams)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to get slots of `%s` cluster. Error: %s", tgtName, err)
+		return err
+	}
+
+	tmpDumpPath := path.Join(path.Dir(tmpBackupFile), "redis_cluster_"+tgtName+"_"+misc.GetDateTimeNow(""))
+	if err = os.MkdirAll(tmpDumpPath, os.ModePerm); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+		return err
+	}
+	defer func() { _ = os.RemoveAll(tmpDumpPath) }()
+
+	logCh <- logger.Log(j.name, "").Infof("Starting to dump `%s` cluster with %d shards", tgtName, len(shards))
+
+	var manifest clusterManifest
+	for i, shard := range shards {
+		fileName := fmt.Sprintf("shard_%d.rdb", i+1)
+		if err = j.dumpRdb(logCh, path.Join(tmpDumpPath, fileName), shard.Addr, tgt); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to dump shard `%s` of `%s` cluster. Error: %s", shard.Addr, tgtName, err)
+			return err
+		}
+		manifest.Shards = append(manifest.Shards, clusterManifestShard{ClusterShard: shard, File: fileName})
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path.Join(tmpDumpPath, "manifest.json"), data, 0600); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to write cluster manifest: %s", err)
+		return err
+	}
+
+	if err = targz.Tar(tmpDumpPath, tmpBackupFile, false, tgt.gzip, false, nil); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
+		if serr, ok := err.(targz.Error); ok {
+			logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", serr.Stderr)
+		}
+		return err
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Dumping of cluster `%s` completed", tgtName)
+	logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+	j.dumpedObjects[tgtName] = interfaces.DumpObject{TmpFile: tmpBackupFile}
+
+	return nil
+}
+
+// dumpRdb fetches RDB file from the node with `redis-cli --rdb`. If node address is empty, connect params are used
+func (j *job) dumpRdb(logCh chan logger.LogRecord, rdbFile, nodeAddr string, tgt target) error {
+	var stderr, stdout bytes.Buffer
+
+	var args []string
+	// define command args
+	// add db connect
+	switch {
+	case nodeAddr != "":
+		host, port, err := net.SplitHostPort(nodeAddr)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-h", host, "-p", port)
+	case tgt.connParams.Socket != "":
+		args = append(args, "-s", tgt.connParams.Socket)
+	default:
+		args = append(args, "-h", tgt.connParams.Host, "-p", tgt.connParams.Port)
+	}
+	if tgt.connParams.User != "" {
+		args = append(args, "--user", tgt.connParams.User)
+	}
+	if tgt.connParams.TLS {
+		args = append(args, "--tls")
+		if tgt.connParams.TLSCAFile != "" {
+			args = append(args, "--cacert", tgt.connParams.TLSCAFile)
+		}
+		if tgt.connParams.TLSCertFile != "" {
+			args = append(args, "--cert", tgt.connParams.TLSCertFile, "--key", tgt.connParams.TLSKeyFile)
+		}
+		if tgt.connParams.TLSInsecure {
+			args = append(args, "--insecure")
+		}
+	}
+	// add data catalog path
+	args = append(args, "--rdb", rdbFile)
+
+	cmd := exec.Command("redis-cli", args...)
+	cmd.Env = os.Environ()
+	if tgt.connParams.Passwd != "" {
+		cmd.Env = append(cmd.Env, "REDISCLI_AUTH="+tgt.connParams.Passwd)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", misc.MaskSecrets(cmd.String()))
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s", err, stderr.String())
+	}
+
+	return nil
+}
+
 func (j *job) Close() error {
 	for _, st := range j.storages {
 		_ = st.Close()
@@ -0,0 +1,498 @@
+package s3_bucket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mb0/glob"
+	"github.com/minio/minio-go/v7"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/exec_cmd"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/logger"
+	"nxs-backup/modules/storage/s3"
+)
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	changedOnly      bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	client      *minio.Client
+	bucket      string
+	prefix      string
+	excludes    []string
+	changedOnly bool
+	gzip        bool
+}
+
+// manifest describes objects of the bucket at the moment of the backup
+type manifest struct {
+	Bucket      string                    `json:"bucket"`
+	Prefix      string                    `json:"prefix"`
+	CreatedAt   string                    `json:"created_at"`
+	ChangedOnly bool                      `json:"changed_only"`
+	Objects     map[string]manifestObject `json:"objects"`
+	Archived    []string                  `json:"archived"`
+	Deleted     []string                  `json:"deleted,omitempty"`
+}
+
+type manifestObject struct {
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type SourceParams struct {
+	Name          string
+	ConnectParams s3.Params
+	Targets       []string // Buckets with optional prefixes in `bucket/prefix` format
+	Excludes      []string // Glob patterns of object keys to be excluded
+	ChangedOnly   bool     // Whether only objects changed since the last backup are archived
+	Gzip          bool
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	// check if tar available
+	if _, err := exec_cmd.Exec("tar", "--version"); err != nil {
+		return nil, fmt.Errorf("Job `%s` init failed. Can't check `tar` version. Please install `tar`. Error: %s ", jp.Name, err)
+	}
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for i, src := range jp.Sources {
+
+		// backups of changed objects are stored by the incremental scheme, which is defined for the whole job
+		if i == 0 {
+			j.changedOnly = src.ChangedOnly
+		} else if src.ChangedOnly != j.changedOnly {
+			return nil, fmt.Errorf("Job `%s` init failed. The `s3_changed_only` option must be the same for all sources of the job ", jp.Name)
+		}
+
+		client, err := s3.NewClient(src.ConnectParams)
+		if err != nil {
+			return nil, fmt.Errorf("Job `%s` init failed. S3 client init error: %s ", jp.Name, err)
+		}
+
+		for _, tgt := range src.Targets {
+			bucket, prefix, _ := strings.Cut(strings.TrimPrefix(tgt, "/"), "/")
+
+			ok, err := client.BucketExists(context.Background(), bucket)
+			if err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to check bucket `%s`. Error: %s ", jp.Name, bucket, err)
+			}
+			if !ok {
+				return nil, fmt.Errorf("Job `%s` init failed. Bucket `%s` doesn't exist ", jp.Name, bucket)
+			}
+
+			ofsPart := src.Name + "/" + strings.ReplaceAll(strings.Trim(path.Join(bucket, prefix), "/"), "/", "___")
+			j.targets[ofsPart] = target{
+				client:      client,
+				bucket:      bucket,
+				prefix:      prefix,
+				excludes:    src.Excludes,
+				changedOnly: src.ChangedOnly,
+				gzip:        src.Gzip,
+			}
+		}
+	}
+
+	return j, nil
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "s3_bucket"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return j.changedOnly
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofsPart, tgt := range j.targets {
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "tar", "", tgt.gzip)
+		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		var (
+			base          *manifest
+			skipUnchanged bool
+		)
+		if j.changedOnly {
+			var initChain bool
+			var baseMtd string
+			initChain, baseMtd, base, err = j.getBaseManifest(logCh, ofsPart)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+
+			if initChain {
+				logCh <- logger.Log(j.name, "").Info("Incremental backup will be reinitialized.")
+
+				if err = j.DeleteOldBackups(logCh, ofsPart); err != nil {
+					errs = multierror.Append(errs, err)
+				}
+				if _, err = os.Create(tmpBackupFile + ".init"); err != nil {
+					errs = multierror.Append(errs, err)
+				}
+			}
+			// only daily backups may be skipped, other backups are the bases of the next ones
+			skipUnchanged = baseMtd == "day.inc"
+		}
+
+		dumpObj, err := j.createTmpBackup(logCh, tmpBackupFile, ofsPart, tgt, base, skipUnchanged)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if dumpObj.TmpFile == "" {
+			continue
+		}
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+		j.dumpedObjects[ofsPart] = dumpObj
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// createTmpBackup downloads objects of the bucket and packs them into tar. If the base manifest is passed, only
+// objects changed since the base backup are archived. The empty dump object is returned if there are no changes
+// and the unchanged target may be skipped
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile, ofsPart string, tgt target, base *manifest, skipUnchanged bool) (interfaces.DumpObject, error) {
+	var dumpObj interfaces.DumpObject
+
+	// listing is cancelled on return, so its goroutine isn't leaked on errors
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manifestName := path.Base(ofsPart) + ".manifest.json"
+
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` bucket backup", path.Join(tgt.bucket, tgt.prefix))
+
+	mf := manifest{
+		Bucket:      tgt.bucket,
+		Prefix:      tgt.prefix,
+		CreatedAt:   misc.GetDateTimeNow(""),
+		ChangedOnly: tgt.changedOnly,
+		Objects:     make(map[string]manifestObject),
+	}
+	for obj := range tgt.client.ListObjects(ctx, tgt.bucket, minio.ListObjectsOptions{Prefix: tgt.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to list objects of `%s` bucket. Error: %s", tgt.bucket, obj.Err)
+			return dumpObj, obj.Err
+		}
+		if strings.HasSuffix(obj.Key, "/") {
+			continue
+		}
+		excluded, err := matchAny(tgt.excludes, obj.Key)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to process exclude pattern. Error: %s", err)
+			return dumpObj, err
+		}
+		if !excluded {
+			mf.Objects[obj.Key] = manifestObject{ETag: obj.ETag, Size: obj.Size}
+		}
+	}
+
+	var changed []string
+	for key, obj := range mf.Objects {
+		if base != nil {
+			if baseObj, ok := base.Objects[key]; ok && baseObj.ETag == obj.ETag {
+				continue
+			}
+		}
+		changed = append(changed, key)
+	}
+	sort.Strings(changed)
+	if base != nil {
+		for key := range base.Objects {
+			if _, ok := mf.Objects[key]; !ok {
+				mf.Deleted = append(mf.Deleted, key)
+			}
+		}
+		sort.Strings(mf.Deleted)
+		if skipUnchanged && len(changed) == 0 && len(mf.Deleted) == 0 {
+			logCh <- logger.Log(j.name, "").Infof("Objects of `%s` bucket have not changed since the last backup, skipping", path.Join(tgt.bucket, tgt.prefix))
+			return dumpObj, nil
+		}
+	}
+
+	// objects are downloaded into the dir named as the bucket, so the archive contains the bucket keys structure
+	objectsDir := path.Join(path.Dir(tmpBackupFile), path.Base(ofsPart)+"_objects")
+	bucketDir := path.Join(objectsDir, tgt.bucket)
+	defer func() { _ = os.RemoveAll(objectsDir) }()
+	if err := os.MkdirAll(bucketDir, os.ModePerm); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+		return dumpObj, err
+	}
+
+	dirKeys := getDirKeys(changed)
+	for _, key := range changed {
+		dst := filepath.Join(bucketDir, filepath.FromSlash(key))
+		// object keys may contain `..` elements, such objects can't be saved into the dir safely
+		if !strings.HasPrefix(dst, bucketDir+string(filepath.Separator)) {
+			logCh <- logger.Log(j.name, "").Warnf("Object `%s` has unsafe key, skipping", key)
+			continue
+		}
+		// the key like `a` can't be saved as the file if there is the key like `a/b`, which requires the dir `a`
+		if dirKeys[key] {
+			logCh <- logger.Log(j.name, "").Warnf("Object `%s` clashes with the directory of other objects with the same key prefix, skipping", key)
+			continue
+		}
+		if err := tgt.client.FGetObject(ctx, tgt.bucket, key, dst, minio.GetObjectOptions{}); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to download object `%s`. Error: %s", key, err)
+			return dumpObj, err
+		}
+		mf.Archived = append(mf.Archived, key)
+	}
+	logCh <- logger.Log(j.name, "").Infof("Downloaded %d of %d objects", len(mf.Archived), len(mf.Objects))
+
+	if err := targz.Tar(bucketDir, tmpBackupFile, false, tgt.gzip, false, nil); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to make tar: %s", err)
+		if serr, ok := err.(targz.Error); ok {
+			logCh <- logger.Log(j.name, "").Debugf("STDERR: %s", serr.Stderr)
+		}
+		return dumpObj, err
+	}
+	dumpObj.TmpFile = tmpBackupFile
+
+	// the manifest of the incremental backup is its metadata, the next backups are based on it
+	if tgt.changedOnly {
+		if err := writeManifest(tmpBackupFile+".inc", mf); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to save objects manifest. Error: %s", err)
+			return dumpObj, err
+		}
+		logCh <- logger.Log(j.name, "").Infof("Backup of `%s` bucket completed", path.Join(tgt.bucket, tgt.prefix))
+		return dumpObj, nil
+	}
+
+	mfFile := path.Join(path.Dir(tmpBackupFile), manifestName)
+	if err := writeManifest(mfFile, mf); err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Unable to save objects manifest. Error: %s", err)
+	} else {
+		dumpObj.MetaFiles = []string{mfFile}
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Backup of `%s` bucket completed", path.Join(tgt.bucket, tgt.prefix))
+
+	return dumpObj, nil
+}
+
+// getBaseManifest returns the manifest of the backup the new backup of changed objects will be based on.
+// Yearly backup is full. Monthly backups are based on the yearly one, decade backups are based on the monthly ones
+// and daily backups are based on the decade ones, so removing of outdated months never breaks a chain
+func (j *job) getBaseManifest(logCh chan logger.LogRecord, ofsPart string) (initChain bool, baseMtd string, mf *manifest, err error) {
+	dom := misc.GetDateTimeNow("dom")
+
+	if misc.GetDateTimeNow("doy") == misc.YearlyBackupDay {
+		return true, "", nil, nil
+	}
+
+	switch {
+	case dom == misc.MonthlyBackupDay:
+		baseMtd = "year.inc"
+	case misc.Contains(misc.DecadesBackupDays, dom):
+		baseMtd = "month.inc"
+	default:
+		baseMtd = "day.inc"
+	}
+
+	if _, err = j.getMetadataFile(logCh, ofsPart, "year.inc"); err != nil {
+		logCh <- logger.Log(j.name, "").Warnf("Failed to find backup year metadata. Error: %v", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return true, "", nil, err
+	}
+
+	data, err := j.getMetadataFile(logCh, ofsPart, baseMtd)
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to find backup `%s` metadata.", baseMtd)
+		return
+	}
+
+	mf = &manifest{}
+	if err = json.Unmarshal(data, mf); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to parse `%s` metadata. Error: %v", baseMtd, err)
+		return false, "", nil, err
+	}
+
+	return
+}
+
+// getMetadataFile returns content of the incremental metadata file. Storages are checked from the last one
+func (j *job) getMetadataFile(logCh chan logger.LogRecord, ofsPart, metadata string) ([]byte, error) {
+	year := misc.GetDateTimeNow("year")
+
+	for i := len(j.storages) - 1; i >= 0; i-- {
+		st := j.storages[i]
+
+		reader, err := st.GetFileReader(path.Join(ofsPart, year, "inc_meta_info", metadata))
+		if err != nil {
+			logCh <- logger.Log(j.name, st.GetName()).Warnf("Unable to get previous metadata '%s' from storage. Error: %s ", metadata, err)
+			continue
+		}
+		data, err := io.ReadAll(reader)
+		if c, ok := reader.(io.Closer); ok {
+			_ = c.Close()
+		}
+		if err != nil {
+			logCh <- logger.Log(j.name, st.GetName()).Warnf("Unable to read previous metadata '%s'. Error: %s ", metadata, err)
+			continue
+		}
+		return data, nil
+	}
+
+	return nil, fs.ErrNotExist
+}
+
+// getDirKeys returns keys which are the directories of other keys, e.g. `a` for `a/b`
+func getDirKeys(keys []string) map[string]bool {
+	keySet := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		keySet[key] = true
+	}
+
+	dirKeys := make(map[string]bool)
+	for _, key := range keys {
+		for dir := path.Dir(key); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if keySet[dir] {
+				dirKeys[dir] = true
+			}
+		}
+	}
+
+	return dirKeys
+}
+
+func writeManifest(filePath string, mf manifest) error {
+	data, err := json.MarshalIndent(mf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+func matchAny(patterns []string, name string) (bool, error) {
+	for _, p := range patterns {
+		match, err := glob.Match(p, name)
+		if err != nil {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package s3_bucket
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/logger"
+	"nxs-backup/modules/storage"
+	"nxs-backup/modules/storage/local"
+	"nxs-backup/modules/storage/s3"
+)
+
+// TestChangedOnly runs against MinIO server, e.g. with MINIO_ENDPOINT=127.0.0.1:9000. Credentials are taken from
+// MINIO_ROOT_USER and MINIO_ROOT_PASSWORD (`minioadmin` by default). The test is skipped if MINIO_ENDPOINT isn't set
+func TestChangedOnly(t *testing.T) {
+	endpoint := os.Getenv("MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("MINIO_ENDPOINT isn't set")
+	}
+	if misc.GetDateTimeNow("doy") == misc.YearlyBackupDay {
+		t.Skip("the incremental chain is reinitialized on the yearly backup day")
+	}
+
+	params := s3.Params{
+		AccessKeyID: envOrDefault("MINIO_ROOT_USER", "minioadmin"),
+		SecretKey:   envOrDefault("MINIO_ROOT_PASSWORD", "minioadmin"),
+		Endpoint:    endpoint,
+	}
+	client, err := s3.NewClient(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := fmt.Sprintf("nxs-backup-test-%d", time.Now().UnixNano())
+	if err = client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { removeBucket(client, bucket) })
+
+	putObject(t, client, bucket, "data/unchanged", "unchanged")
+	putObject(t, client, bucket, "data/changed", "original")
+	putObject(t, client, bucket, "data/deleted", "deleted")
+
+	bakDir := t.TempDir()
+	st := local.Init()
+	st.SetBackupPath(bakDir)
+	st.SetRetention(storage.Retention{Months: 1})
+
+	logCh := make(chan logger.LogRecord)
+	go func() {
+		for range logCh {
+		}
+	}()
+	t.Cleanup(func() { close(logCh) })
+
+	jb, err := Init(JobParams{
+		Name:     "test",
+		TmpDir:   t.TempDir(),
+		Storages: interfaces.Storages{st},
+		Sources: []SourceParams{{
+			Name:          "src",
+			ConnectParams: params,
+			Targets:       []string{bucket + "/data"},
+			ChangedOnly:   true,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := jb.(*job)
+	ofsPart := "src/" + bucket + "___data"
+
+	t.Run("full", func(t *testing.T) {
+		if err := j.DoBackup(logCh, t.TempDir()); err != nil {
+			t.Fatalf("DoBackup() error = %v", err)
+		}
+		assertArchived(t, bakDir, ofsPart, bucket, []string{"data/changed", "data/deleted", "data/unchanged"})
+
+		// the chain is initialized, so the backup is the base of all next ones
+		mf := getStoredManifest(t, st, ofsPart, "year.inc")
+		if len(mf.Objects) != 3 || len(mf.Deleted) != 0 {
+			t.Errorf("year manifest has %d objects and %d deleted, want 3 and 0", len(mf.Objects), len(mf.Deleted))
+		}
+	})
+
+	t.Run("changed only", func(t *testing.T) {
+		putObject(t, client, bucket, "data/changed", "modified")
+		putObject(t, client, bucket, "data/added", "added")
+		if err := client.RemoveObject(context.Background(), bucket, "data/deleted", minio.RemoveObjectOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		// backups are named by minutes, so the previous one would be overwritten
+		time.Sleep(time.Until(time.Now().Truncate(time.Minute).Add(time.Minute)))
+
+		j.dumpedObjects = make(map[string]interfaces.DumpObject)
+		if err := j.DoBackup(logCh, t.TempDir()); err != nil {
+			t.Fatalf("DoBackup() error = %v", err)
+		}
+		assertArchived(t, bakDir, ofsPart, bucket, []string{"data/added", "data/changed"})
+	})
+
+	t.Run("skip unchanged", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		tgt := j.targets[ofsPart]
+
+		// the base manifest describes the current objects of the bucket
+		dumpObj, err := j.createTmpBackup(logCh, filepath.Join(tmpDir, "base.tar"), ofsPart, tgt, nil, false)
+		if err != nil || dumpObj.TmpFile == "" {
+			t.Fatalf("createTmpBackup() = %v, %v", dumpObj, err)
+		}
+		base := readManifest(t, filepath.Join(tmpDir, "base.tar.inc"))
+		if !reflect.DeepEqual(base.Deleted, []string(nil)) {
+			t.Errorf("base manifest has deleted objects %v", base.Deleted)
+		}
+
+		dumpObj, err = j.createTmpBackup(logCh, filepath.Join(tmpDir, "next.tar"), ofsPart, tgt, base, true)
+		if err != nil {
+			t.Fatalf("createTmpBackup() error = %v", err)
+		}
+		if dumpObj.TmpFile != "" {
+			t.Errorf("createTmpBackup() created backup %s, want skip", dumpObj.TmpFile)
+		}
+
+		// deleted objects aren't skipped
+		if err = client.RemoveObject(context.Background(), bucket, "data/added", minio.RemoveObjectOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		dumpObj, err = j.createTmpBackup(logCh, filepath.Join(tmpDir, "next.tar"), ofsPart, tgt, base, true)
+		if err != nil || dumpObj.TmpFile == "" {
+			t.Fatalf("createTmpBackup() = %v, %v", dumpObj, err)
+		}
+		if mf := readManifest(t, filepath.Join(tmpDir, "next.tar.inc")); !reflect.DeepEqual(mf.Deleted, []string{"data/added"}) {
+			t.Errorf("manifest deleted objects = %v, want [data/added]", mf.Deleted)
+		}
+	})
+}
+
+// MinIO doesn't list objects nested into the key of other object, so clashing keys are tested without the server
+func TestGetDirKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []string
+		want map[string]bool
+	}{
+		{name: "no clash", keys: []string{"a/b", "a/c", "ab"}, want: map[string]bool{}},
+		{name: "file and dir", keys: []string{"a", "a/b"}, want: map[string]bool{"a": true}},
+		{name: "nested dirs", keys: []string{"a", "a/b", "a/b/c/d"}, want: map[string]bool{"a": true, "a/b": true}},
+		{name: "double slash", keys: []string{"a", "a//b"}, want: map[string]bool{"a": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getDirKeys(tt.keys); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getDirKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func putObject(t *testing.T, client *minio.Client, bucket, key, content string) {
+	t.Helper()
+
+	_, err := client.PutObject(context.Background(), bucket, key, strings.NewReader(content), int64(len(content)), minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func removeBucket(client *minio.Client, bucket string) {
+	ctx := context.Background()
+	for obj := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+		_ = client.RemoveObject(ctx, bucket, obj.Key, minio.RemoveObjectOptions{})
+	}
+	_ = client.RemoveBucket(ctx, bucket)
+}
+
+// assertArchived checks objects of the latest daily backup in the storage
+func assertArchived(t *testing.T, bakDir, ofsPart, bucket string, want []string) {
+	t.Helper()
+
+	month := fmt.Sprintf("month_%02s", misc.GetDateTimeNow("moy"))
+	dayDir := path.Join(misc.GetDateTimeNow("year"), month, misc.GetDecadeDaySubdir())
+	files, err := filepath.Glob(filepath.Join(bakDir, ofsPart, dayDir, "*.tar"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no backups in `%s`: %v", dayDir, err)
+	}
+	sort.Strings(files)
+
+	if got := listArchive(t, files[len(files)-1], bucket); !reflect.DeepEqual(got, want) {
+		t.Errorf("archived objects = %v, want %v", got, want)
+	}
+}
+
+func getStoredManifest(t *testing.T, st interfaces.Storage, ofsPart, metadata string) *manifest {
+	t.Helper()
+
+	reader, err := st.GetFileReader(path.Join(ofsPart, misc.GetDateTimeNow("year"), "inc_meta_info", metadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mf := &manifest{}
+	if err = json.Unmarshal(data, mf); err != nil {
+		t.Fatal(err)
+	}
+	return mf
+}
+
+func readManifest(t *testing.T, file string) *manifest {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mf := &manifest{}
+	if err = json.Unmarshal(data, mf); err != nil {
+		t.Fatal(err)
+	}
+	return mf
+}
+
+// listArchive returns sorted keys of objects in the archive
+func listArchive(t *testing.T, file, bucket string) (keys []string) {
+	t.Helper()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			keys = append(keys, strings.TrimPrefix(hdr.Name, bucket+"/"))
+		}
+	}
+	sort.Strings(keys)
+
+	return
+}
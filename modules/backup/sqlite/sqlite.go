@@ -0,0 +1,269 @@
+package sqlite
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/mb0/glob"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/backend/exec_cmd"
+	"nxs-backup/modules/backend/targz"
+	"nxs-backup/modules/logger"
+)
+
+type job struct {
+	name             string
+	tmpDir           string
+	needToMakeBackup bool
+	safetyBackup     bool
+	deferredCopying  bool
+	storages         interfaces.Storages
+	targets          map[string]target
+	dumpedObjects    map[string]interfaces.DumpObject
+}
+
+type target struct {
+	path string
+	gzip bool
+}
+
+type JobParams struct {
+	Name             string
+	TmpDir           string
+	NeedToMakeBackup bool
+	SafetyBackup     bool
+	DeferredCopying  bool
+	Storages         interfaces.Storages
+	Sources          []SourceParams
+}
+
+type SourceParams struct {
+	Name     string
+	Targets  []string
+	Excludes []string
+	Gzip     bool
+}
+
+func Init(jp JobParams) (interfaces.Job, error) {
+
+	// check if sqlite3 available
+	if _, err := exec_cmd.Exec("sqlite3", "--version"); err != nil {
+		return nil, fmt.Errorf("Job `%s` init failed. Can't check `sqlite3` version. Please install `sqlite3`. Error: %s ", jp.Name, err)
+	}
+
+	j := &job{
+		name:             jp.Name,
+		tmpDir:           jp.TmpDir,
+		needToMakeBackup: jp.NeedToMakeBackup,
+		safetyBackup:     jp.SafetyBackup,
+		deferredCopying:  jp.DeferredCopying,
+		storages:         jp.Storages,
+		targets:          make(map[string]target),
+		dumpedObjects:    make(map[string]interfaces.DumpObject),
+	}
+
+	for _, src := range jp.Sources {
+
+		for _, targetPattern := range src.Targets {
+
+			targetOfsList, err := filepath.Glob(targetPattern)
+			if err != nil {
+				return nil, fmt.Errorf("Job `%s` init failed. Unable to process pattern: %s. Error: %s. ", jp.Name, targetPattern, err)
+			}
+
+			for _, ofs := range targetOfsList {
+				skipOfs := false
+				for _, pattern := range src.Excludes {
+					match, err := glob.Match(pattern, ofs)
+					if err != nil {
+						return nil, fmt.Errorf("Job `%s` init failed. Unable to process pattern: %s. Error: %s. ", jp.Name, pattern, err)
+					}
+					if match {
+						skipOfs = true
+						break
+					}
+				}
+				if skipOfs {
+					continue
+				}
+
+				if fi, err := os.Stat(ofs); err != nil || fi.IsDir() {
+					continue
+				}
+
+				ofsPart := src.Name + "/" + misc.GetOfsPart(targetPattern, ofs)
+				j.targets[ofsPart] = target{
+					path: ofs,
+					gzip: src.Gzip,
+				}
+			}
+		}
+	}
+
+	return j, nil
+}
+
+func (j *job) GetName() string {
+	return j.name
+}
+
+func (j *job) GetTempDir() string {
+	return j.tmpDir
+}
+
+func (j *job) GetType() string {
+	return "sqlite"
+}
+
+func (j *job) GetTargetOfsList() (ofsList []string) {
+	for ofs := range j.targets {
+		ofsList = append(ofsList, ofs)
+	}
+	return
+}
+
+func (j *job) GetStoragesCount() int {
+	return len(j.storages)
+}
+
+func (j *job) GetDumpObjects() map[string]interfaces.DumpObject {
+	return j.dumpedObjects
+}
+
+func (j *job) SetDumpObjectDelivered(ofs string) {
+	dumpObj := j.dumpedObjects[ofs]
+	dumpObj.Delivered = true
+	j.dumpedObjects[ofs] = dumpObj
+}
+
+func (j *job) IsBackupSafety() bool {
+	return j.safetyBackup
+}
+
+func (j *job) NeedToMakeBackup() bool {
+	return j.needToMakeBackup
+}
+
+func (j *job) NeedToUpdateIncMeta() bool {
+	return false
+}
+
+func (j *job) DeleteOldBackups(logCh chan logger.LogRecord, ofsPath string) error {
+	return j.storages.DeleteOldBackups(logCh, j, ofsPath)
+}
+
+func (j *job) CleanupTmpData() error {
+	return j.storages.CleanupTmpData(j)
+}
+
+func (j *job) DoBackup(logCh chan logger.LogRecord, tmpDir string) error {
+	var errs *multierror.Error
+
+	for ofsPart, tgt := range j.targets {
+
+		tmpBackupFile := misc.GetFileFullPath(tmpDir, ofsPart, "sqlite", "", tgt.gzip)
+		err := os.MkdirAll(path.Dir(tmpBackupFile), os.ModePerm)
+		if err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create tmp dir with next error: %s", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		if err = j.createTmpBackup(logCh, tmpBackupFile, tgt); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to create temp backups %s", tmpBackupFile)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		logCh <- logger.Log(j.name, "").Debugf("Created temp backup %s", tmpBackupFile)
+
+		j.dumpedObjects[ofsPart] = interfaces.DumpObject{TmpFile: tmpBackupFile}
+		if !j.deferredCopying {
+			if err = j.storages.Delivery(logCh, j); err != nil {
+				logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	if err := j.storages.Delivery(logCh, j); err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Failed to delivery backup. Errors: %v", err)
+		errs = multierror.Append(errs, err)
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// createTmpBackup makes a consistent copy of the database with the SQLite online backup API
+// and checks integrity of the copy
+func (j *job) createTmpBackup(logCh chan logger.LogRecord, tmpBackupFile string, tgt target) error {
+
+	tmpCopy := strings.TrimSuffix(tmpBackupFile, ".gz")
+
+	logCh <- logger.Log(j.name, "").Infof("Starting a `%s` backup", tgt.path)
+
+	// the path is quoted for the dot-command, single quotes inside are escaped by doubling
+	out, err := j.execSqlite(logCh, tgt.path, ".backup '"+strings.ReplaceAll(tmpCopy, "'", "''")+"'")
+	if err != nil {
+		logCh <- logger.Log(j.name, "").Errorf("Unable to backup `%s`. Error: %s", tgt.path, err)
+		return err
+	}
+	if out != "" {
+		logCh <- logger.Log(j.name, "").Debugf("STDOUT: %s", out)
+	}
+
+	out, err = j.execSqlite(logCh, tmpCopy, "PRAGMA integrity_check;")
+	if err != nil {
+		_ = os.Remove(tmpCopy)
+		logCh <- logger.Log(j.name, "").Errorf("Unable to check integrity of `%s` copy. Error: %s", tgt.path, err)
+		return err
+	}
+	if out != "ok" {
+		_ = os.Remove(tmpCopy)
+		err = fmt.Errorf("integrity check of `%s` copy failed: %s", tgt.path, out)
+		logCh <- logger.Log(j.name, "").Error(err)
+		return err
+	}
+
+	if tgt.gzip {
+		if err = targz.GZip(tmpCopy, tmpBackupFile); err != nil {
+			logCh <- logger.Log(j.name, "").Errorf("Unable to archivate tmp backup: %s", err)
+			return err
+		}
+		_ = os.Remove(tmpCopy)
+	}
+
+	logCh <- logger.Log(j.name, "").Infof("Backup of `%s` completed", tgt.path)
+
+	return nil
+}
+
+func (j *job) execSqlite(logCh chan logger.LogRecord, dbPath, command string) (string, error) {
+	var stderr, stdout bytes.Buffer
+
+	cmd := exec.Command("sqlite3", "-bail", dbPath, command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	logCh <- logger.Log(j.name, "").Debugf("Dump cmd: %s", cmd.String())
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s %s", err, stderr.String())
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func (j *job) Close() error {
+	for _, st := range j.storages {
+		_ = st.Close()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package clickhouse_connect
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type Params struct {
+	User     string // Username
+	Passwd   string // Password
+	Host     string // Network host
+	Port     string // HTTP interface port
+	Secure   bool   // Whether to use HTTPS
+	Insecure bool   // Skip server certificate verification
+	TCPPort  string // Native protocol port, used by external tools
+}
+
+// Conn executes queries via ClickHouse HTTP interface
+type Conn struct {
+	client http.Client
+	url    string
+	params Params
+}
+
+// GetConnect returns connect to ClickHouse server
+func GetConnect(params Params) (*Conn, error) {
+
+	scheme := "http"
+	if params.Secure {
+		scheme = "https"
+	}
+	port := params.Port
+	if port == "" {
+		port = "8123"
+		if params.Secure {
+			port = "8443"
+		}
+	}
+
+	c := &Conn{
+		client: http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: params.Insecure},
+			},
+		},
+		url:    fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(params.Host, port)),
+		params: params,
+	}
+
+	if _, err := c.Query("SELECT 1"); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Query executes the query and returns the result in TabSeparated format
+func (c *Conn) Query(query string) (string, error) {
+
+	req, err := http.NewRequest(http.MethodPost, c.url, strings.NewReader(query))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-ClickHouse-User", c.params.User)
+	req.Header.Set("X-ClickHouse-Key", c.params.Passwd)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("query failed: %s", strings.TrimSpace(string(body)))
+	}
+
+	return string(body), nil
+}
+
+// QueryList executes the query and returns values of the first column
+func (c *Conn) QueryList(query string) ([]string, error) {
+	res, err := c.Query(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []string
+	for _, line := range strings.Split(strings.TrimSpace(res), "\n") {
+		if line != "" {
+			list = append(list, strings.Split(line, "\t")[0])
+		}
+	}
+	return list, nil
+}
+
+// QuoteIdentifier quotes database or table name for using in queries
+func QuoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
+
+// QuoteString quotes string literal for using in queries
+func QuoteString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
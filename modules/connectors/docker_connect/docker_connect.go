@@ -0,0 +1,147 @@
+package docker_connect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultSocket = "/var/run/docker.sock"
+
+type Params struct {
+	Socket string // Path to Docker Engine API socket
+}
+
+// Volume describes Docker volume
+type Volume struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver"`
+	Mountpoint string            `json:"Mountpoint"`
+	Labels     map[string]string `json:"Labels"`
+}
+
+// Container describes Docker container
+type Container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	State  string            `json:"State"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// Conn executes requests to Docker Engine API over unix socket
+type Conn struct {
+	client http.Client
+}
+
+// GetConnect returns connect to Docker Engine
+func GetConnect(params Params) (*Conn, error) {
+
+	socket := params.Socket
+	if socket == "" {
+		socket = defaultSocket
+	}
+
+	c := &Conn{
+		client: http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+
+	if err := c.Do(http.MethodGet, "/_ping", nil); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ListVolumes returns volumes matching the filters, e.g. {"label": ["backup=true"]}
+func (c *Conn) ListVolumes(filters map[string][]string) ([]Volume, error) {
+	var res struct {
+		Volumes []Volume `json:"Volumes"`
+	}
+	if err := c.Do(http.MethodGet, "/volumes"+filtersQuery(filters), &res); err != nil {
+		return nil, err
+	}
+	return res.Volumes, nil
+}
+
+// ListContainers returns running containers matching the filters, e.g. {"volume": ["data"]}
+func (c *Conn) ListContainers(filters map[string][]string) ([]Container, error) {
+	var res []Container
+	if err := c.Do(http.MethodGet, "/containers/json"+filtersQuery(filters), &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ContainerAction executes the action (`pause`, `unpause`, `stop`, `start`) on the container
+func (c *Conn) ContainerAction(id, action string) error {
+	return c.Do(http.MethodPost, "/containers/"+url.PathEscape(id)+"/"+action, nil)
+}
+
+// ExportContainer writes the tar archive of the container filesystem to w
+func (c *Conn) ExportContainer(id string, w io.Writer) error {
+	resp, err := c.request(http.MethodGet, "/containers/"+url.PathEscape(id)+"/export")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Do executes the request and decodes JSON response into the result if it isn't nil
+func (c *Conn) Do(method, path string, result interface{}) error {
+	resp, err := c.request(method, path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func (c *Conn) request(method, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, "http://docker"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	// 304 is returned if the container is already in the requested state
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		defer func() { _ = resp.Body.Close() }()
+		var msg struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&msg)
+		return nil, fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(msg.Message))
+	}
+
+	return resp, nil
+}
+
+func filtersQuery(filters map[string][]string) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(filters)
+	return "?filters=" + url.QueryEscape(string(data))
+}
@@ -0,0 +1,121 @@
+package elastic_connect
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type Params struct {
+	User        string // Username
+	Passwd      string // Password
+	Host        string // Network host
+	Port        string // HTTP port
+	Secure      bool   // Whether to use HTTPS
+	TLSCAFile   string // Path to TLS CA file
+	TLSInsecure bool   // Skip server certificate verification
+}
+
+// ServerInfo describes Elasticsearch or OpenSearch server
+type ServerInfo struct {
+	ClusterName string `json:"cluster_name"`
+	Version     struct {
+		Number       string `json:"number"`
+		Distribution string `json:"distribution"`
+	} `json:"version"`
+}
+
+// Conn executes requests to Elasticsearch/OpenSearch REST API
+type Conn struct {
+	client http.Client
+	url    string
+	params Params
+}
+
+// GetConnect returns connect to Elasticsearch/OpenSearch cluster
+func GetConnect(params Params) (*Conn, *ServerInfo, error) {
+
+	scheme := "http"
+	if params.Secure {
+		scheme = "https"
+	}
+	port := params.Port
+	if port == "" {
+		port = "9200"
+	}
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: params.TLSInsecure}
+	if params.TLSCAFile != "" {
+		ca, err := os.ReadFile(params.TLSCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, nil, fmt.Errorf("unable to load CA certificates from `%s`", params.TLSCAFile)
+		}
+	}
+
+	c := &Conn{
+		client: http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsCfg},
+		},
+		url:    fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(params.Host, port)),
+		params: params,
+	}
+
+	info := &ServerInfo{}
+	if err := c.Do(http.MethodGet, "/", nil, info); err != nil {
+		return nil, nil, err
+	}
+
+	return c, info, nil
+}
+
+// Do executes the request with JSON body and decodes JSON response into the result if it isn't nil
+func (c *Conn) Do(method, path string, body, result interface{}) error {
+
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, rd)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.params.User != "" {
+		req.SetBasicAuth(c.params.User, c.params.Passwd)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s failed with status %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	if result != nil {
+		return json.Unmarshal(data, result)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package etcd_connect
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type Params struct {
+	Endpoints   []string // List of etcd endpoints
+	User        string   // Username
+	Passwd      string   // Password (requires User)
+	TLSCAFile   string   // Path to TLS CA file
+	TLSCertFile string   // Path to client TLS certificate
+	TLSKeyFile  string   // Path to client TLS key
+	TLSInsecure bool     // Skip server certificate verification
+}
+
+// GetConnect returns client connected to the first available endpoint.
+// Snapshots and status requests must be served by the same member, so the client uses only one endpoint
+func GetConnect(params Params) (*clientv3.Client, string, error) {
+	var errs []string
+
+	if len(params.Endpoints) == 0 {
+		return nil, "", fmt.Errorf("no etcd endpoints defined")
+	}
+
+	tlsCfg, err := getTLSConfig(params)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, endpoint := range params.Endpoints {
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   []string{endpoint},
+			DialTimeout: 10 * time.Second,
+			TLS:         tlsCfg,
+			Username:    params.User,
+			Password:    params.Passwd,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", endpoint, err))
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err = cli.Status(ctx, endpoint)
+		cancel()
+		if err != nil {
+			_ = cli.Close()
+			errs = append(errs, fmt.Sprintf("%s: %s", endpoint, err))
+			continue
+		}
+
+		return cli, endpoint, nil
+	}
+
+	return nil, "", fmt.Errorf("unable to connect to etcd: %s", strings.Join(errs, "; "))
+}
+
+func getTLSConfig(params Params) (*tls.Config, error) {
+	if params.TLSCAFile == "" && params.TLSCertFile == "" && !params.TLSInsecure {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: params.TLSInsecure}
+
+	if params.TLSCAFile != "" {
+		ca, err := os.ReadFile(params.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("unable to load CA certificates from `%s`", params.TLSCAFile)
+		}
+	}
+	if params.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(params.TLSCertFile, params.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
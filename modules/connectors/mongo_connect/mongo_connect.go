@@ -19,6 +19,7 @@ type Params struct {
 	RSAddr    string // Replica set address (requires RSName)
 	TLSCAFile string // Path to TLS CA file
 	AuthDB    string // Auth db name
+	ReadPref  string // Read preference mode, e.g. `secondary`
 }
 
 // GetConnectAndHost returns connect to mongo instance and dsn string
@@ -52,6 +53,18 @@ func GetConnectAndHost(params Params) (*mongo.Client, string, error) {
 		opts.Set("authSource", params.AuthDB)
 	}
 
+	rp := readpref.Primary()
+	if params.ReadPref != "" {
+		opts.Set("readPreference", params.ReadPref)
+		mode, err := readpref.ModeFromString(params.ReadPref)
+		if err != nil {
+			return nil, "", err
+		}
+		if rp, err = readpref.New(mode); err != nil {
+			return nil, "", err
+		}
+	}
+
 	connUrl.RawQuery = opts.Encode()
 
 	dsn := connUrl.String()
@@ -60,7 +73,7 @@ func GetConnectAndHost(params Params) (*mongo.Client, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
-	if err = client.Ping(context.TODO(), readpref.Primary()); err != nil {
+	if err = client.Ping(context.TODO(), rp); err != nil {
 		return nil, "", err
 	}
 
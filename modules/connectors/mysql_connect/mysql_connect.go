@@ -2,6 +2,7 @@ package mysql_connect
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
@@ -75,8 +76,7 @@ func GetConnectAndCnfFile(conn Params, sectionName string) (*sqlx.DB, string, er
 		}
 	}
 
-	authFile := misc.GetFileFullPath("/tmp", "my_cnf", "ini", misc.RandString(5), false)
-	err := dumpAuthCfg.SaveTo(authFile)
+	authFile, err := saveAuthFile(dumpAuthCfg)
 	if err != nil {
 		return nil, authFile, err
 	}
@@ -96,3 +96,75 @@ func GetConnectAndCnfFile(conn Params, sectionName string) (*sqlx.DB, string, er
 
 	return db, authFile, err
 }
+
+// GetMasterStatus returns binary log coordinates of the server. The result is empty if the binary log is disabled
+func GetMasterStatus(db *sqlx.DB) (map[string]string, error) {
+	return showStatus(db, "SHOW BINARY LOG STATUS", "SHOW MASTER STATUS")
+}
+
+// GetGlobalPrivileges returns global privileges granted to the current user directly
+func GetGlobalPrivileges(db *sqlx.DB) (privs []string, err error) {
+	err = db.Select(&privs, "SELECT PRIVILEGE_TYPE FROM information_schema.USER_PRIVILEGES WHERE GRANTEE = "+
+		"CONCAT('''', SUBSTRING_INDEX(CURRENT_USER(), '@', 1), '''@''', SUBSTRING_INDEX(CURRENT_USER(), '@', -1), '''')")
+	return
+}
+
+// GetReplicaStatus returns replication status of the server. The result is empty if the server isn't a replica
+func GetReplicaStatus(db *sqlx.DB) (map[string]string, error) {
+	return showStatus(db, "SHOW REPLICA STATUS", "SHOW SLAVE STATUS")
+}
+
+// showStatus runs the first query supported by the server version and returns its first row
+func showStatus(db *sqlx.DB, queries ...string) (status map[string]string, err error) {
+	for _, q := range queries {
+		if status, err = queryRow(db, q); err == nil {
+			return
+		}
+	}
+	return
+}
+
+func queryRow(db *sqlx.DB, query string) (map[string]string, error) {
+	rows, err := db.Queryx(query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	status := make(map[string]string)
+	if rows.Next() {
+		row := make(map[string]interface{})
+		if err = rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		for k, v := range row {
+			switch val := v.(type) {
+			case nil:
+				status[k] = ""
+			case []byte:
+				status[k] = string(val)
+			default:
+				status[k] = fmt.Sprint(val)
+			}
+		}
+	}
+
+	return status, rows.Err()
+}
+
+// saveAuthFile writes auth config to the file in the private tmp dir available only for the owner
+func saveAuthFile(cfg *ini.File) (string, error) {
+	dir, err := misc.GetPrivateTmpDir()
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp(dir, "my_cnf_*.ini")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	_, err = cfg.WriteTo(file)
+	return file.Name(), err
+}
@@ -2,8 +2,10 @@ package psql_connect
 
 import (
 	"fmt"
-	"github.com/jmoiron/sqlx"
 	"net/url"
+	"os"
+
+	"github.com/jmoiron/sqlx"
 
 	_ "github.com/lib/pq"
 )
@@ -54,3 +56,18 @@ func GetConnUrl(params Params) *url.URL {
 func GetConnect(connUrl *url.URL) (*sqlx.DB, error) {
 	return sqlx.Connect("postgres", connUrl.String())
 }
+
+// GetCmdConnUrlAndEnv returns connection url without password and environment with the password for PostgreSQL client tools
+func GetCmdConnUrlAndEnv(connUrl *url.URL) (string, []string) {
+	cmdUrl := *connUrl
+	env := os.Environ()
+
+	if cmdUrl.User != nil {
+		if passwd, ok := cmdUrl.User.Password(); ok {
+			env = append(env, "PGPASSWORD="+passwd)
+		}
+		cmdUrl.User = url.User(cmdUrl.User.Username())
+	}
+
+	return cmdUrl.String(), env
+}
@@ -2,55 +2,207 @@ package redis_connect
 
 import (
 	"context"
-	"net/url"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/go-redis/redis/v8"
 )
 
 type Params struct {
-	Passwd string // Password
-	Host   string // Network host
-	Port   string // Network port
-	Socket string // Socket path
+	User           string   // ACL username
+	Passwd         string   // Password
+	Host           string   // Network host
+	Port           string   // Network port
+	Socket         string   // Socket path
+	TLS            bool     // Whether to use TLS connection
+	TLSCAFile      string   // Path to TLS CA file
+	TLSCertFile    string   // Path to client TLS certificate
+	TLSKeyFile     string   // Path to client TLS key
+	TLSInsecure    bool     // Skip server certificate verification
+	SentinelAddrs  []string // Sentinel addresses in `host:port` format
+	SentinelMaster string   // Name of the master monitored by Sentinel
+	SentinelPasswd string   // Sentinel password
 }
 
-// GetConnectAndDSN returns connect to mongo instance and dsn string
-func GetConnectAndDSN(params Params) (rdb *redis.Client, dsn string, err error) {
+// ClusterShard describes master node of the cluster and slot ranges served by it
+type ClusterShard struct {
+	ID    string   `json:"id"`
+	Addr  string   `json:"addr"`
+	Slots [][2]int `json:"slots"`
+}
+
+// GetConnect returns connect to redis node. If addr is empty, host/port or socket from params are used
+func GetConnect(params Params, addr string) (*redis.Client, error) {
+
+	opt := &redis.Options{
+		Username: params.User,
+		Password: params.Passwd,
+	}
+
+	switch {
+	case addr != "":
+		opt.Network = "tcp"
+		opt.Addr = addr
+	case params.Socket != "":
+		opt.Network = "unix"
+		opt.Addr = params.Socket
+	default:
+		opt.Network = "tcp"
+		opt.Addr = net.JoinHostPort(params.Host, params.Port)
+	}
+
+	if params.TLS {
+		tlsCfg, err := getTLSConfig(params)
+		if err != nil {
+			return nil, err
+		}
+		opt.TLSConfig = tlsCfg
+	}
+
+	rdb := redis.NewClient(opt)
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		_ = rdb.Close()
+		return nil, err
+	}
+
+	return rdb, nil
+}
 
-	connUrl := url.URL{}
-	opts := url.Values{}
+// GetSentinelNode discovers address of the node to be backed up via Sentinel.
+// If preferReplica is set, the address of a healthy replica is returned when available, otherwise the master address
+func GetSentinelNode(params Params, preferReplica bool) (string, error) {
+	var errs []string
 
-	if params.Socket != "" {
-		connUrl.Scheme = "unix"
-		connUrl.Path = params.Socket
-	} else {
-		connUrl.Scheme = "redis"
-		var host string
-		if params.Host != "" {
-			host = params.Host
+	for _, sAddr := range params.SentinelAddrs {
+		opt := &redis.Options{
+			Addr:     sAddr,
+			Password: params.SentinelPasswd,
 		}
-		if params.Port != "" {
-			host += ":" + params.Port
+		if params.TLS {
+			tlsCfg, err := getTLSConfig(params)
+			if err != nil {
+				return "", err
+			}
+			opt.TLSConfig = tlsCfg
 		}
-		connUrl.Host = host
+
+		addr, err := getSentinelNode(redis.NewSentinelClient(opt), params.SentinelMaster, preferReplica)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", sAddr, err))
+			continue
+		}
+		return addr, nil
 	}
 
-	connUrl.RawQuery = opts.Encode()
+	return "", fmt.Errorf("unable to discover node via sentinels: %s", strings.Join(errs, "; "))
+}
+
+func getSentinelNode(sc *redis.SentinelClient, master string, preferReplica bool) (string, error) {
+	defer func() { _ = sc.Close() }()
+	ctx := context.Background()
+
+	if preferReplica {
+		replicas, err := sc.Slaves(ctx, master).Result()
+		if err != nil {
+			return "", err
+		}
+		for _, r := range replicas {
+			info := sliceToMap(r)
+			if strings.Contains(info["flags"], "down") || strings.Contains(info["flags"], "disconnected") ||
+				info["master-link-status"] != "ok" {
+				continue
+			}
+			return net.JoinHostPort(info["ip"], info["port"]), nil
+		}
+	}
 
-	var opt *redis.Options
-	opt, err = redis.ParseURL(connUrl.String())
+	addr, err := sc.GetMasterAddrByName(ctx, master).Result()
 	if err != nil {
-		return
+		return "", err
+	}
+	if len(addr) != 2 {
+		return "", fmt.Errorf("unexpected master address of `%s`", master)
 	}
-	rdb = redis.NewClient(opt)
 
-	err = rdb.Ping(context.Background()).Err()
+	return net.JoinHostPort(addr[0], addr[1]), nil
+}
+
+// GetClusterShards returns master nodes of the cluster with their slot ranges
+func GetClusterShards(params Params) ([]ClusterShard, error) {
 
-	// this is not a bug, this is strange behavior of redis-cli uri usage
-	if params.Passwd != "" {
-		connUrl.User = url.User(params.Passwd)
+	rdb, err := GetConnect(params, "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rdb.Close() }()
+
+	slots, err := rdb.ClusterSlots(context.Background()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	shards := make(map[string]*ClusterShard)
+	for _, s := range slots {
+		if len(s.Nodes) == 0 {
+			continue
+		}
+		// the first node of the slot range is the master
+		master := s.Nodes[0]
+		if _, ok := shards[master.Addr]; !ok {
+			shards[master.Addr] = &ClusterShard{ID: master.ID, Addr: master.Addr}
+		}
+		shards[master.Addr].Slots = append(shards[master.Addr].Slots, [2]int{s.Start, s.End})
+	}
+
+	var res []ClusterShard
+	for _, s := range shards {
+		sort.Slice(s.Slots, func(i, j int) bool { return s.Slots[i][0] < s.Slots[j][0] })
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Slots[0][0] < res[j].Slots[0][0] })
+
+	return res, nil
+}
+
+func getTLSConfig(params Params) (*tls.Config, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: params.TLSInsecure}
+
+	if params.TLSCAFile != "" {
+		ca, err := os.ReadFile(params.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("unable to load CA certificates from `%s`", params.TLSCAFile)
+		}
+	}
+	if params.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(params.TLSCertFile, params.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+func sliceToMap(v interface{}) map[string]string {
+	res := make(map[string]string)
+
+	s, ok := v.([]interface{})
+	if !ok {
+		return res
+	}
+	for i := 0; i+1 < len(s); i += 2 {
+		res[fmt.Sprint(s[i])] = fmt.Sprint(s[i+1])
 	}
-	dsn = connUrl.String()
 
-	return
+	return res
 }
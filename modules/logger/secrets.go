@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const secretMask = "******"
+
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// AddSecret registers the secret value to be masked in logs and notifications
+func AddSecret(s string) {
+	if s == "" {
+		return
+	}
+
+	secrets.Lock()
+	defer secrets.Unlock()
+
+	for _, v := range secrets.values {
+		if v == s {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, s)
+	// longer secrets are masked first in case one contains another
+	sort.Slice(secrets.values, func(i, j int) bool { return len(secrets.values[i]) > len(secrets.values[j]) })
+}
+
+// Masked returns the log record with all registered secrets masked in the message
+func (r LogRecord) Masked() LogRecord {
+	secrets.RLock()
+	defer secrets.RUnlock()
+
+	for _, v := range secrets.values {
+		r.Message = strings.ReplaceAll(r.Message, v, secretMask)
+	}
+	return r
+}
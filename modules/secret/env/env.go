@@ -0,0 +1,25 @@
+package env
+
+import (
+	"fmt"
+	"os"
+)
+
+// Env provides secrets from environment variables, reference is the variable name
+type Env struct{}
+
+func Init() *Env {
+	return &Env{}
+}
+
+func (e *Env) GetName() string {
+	return "env"
+}
+
+func (e *Env) GetSecret(ref string) (string, error) {
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable `%s` isn't set", ref)
+	}
+	return val, nil
+}
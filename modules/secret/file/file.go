@@ -0,0 +1,26 @@
+package file
+
+import (
+	"os"
+	"strings"
+)
+
+// File provides secrets from files, reference is the path to the file.
+// Trailing newlines are trimmed
+type File struct{}
+
+func Init() *File {
+	return &File{}
+}
+
+func (f *File) GetName() string {
+	return "file"
+}
+
+func (f *File) GetSecret(ref string) (string, error) {
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,141 @@
+package vault
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vault provides secrets from HashiCorp Vault KV secrets engine.
+// Reference format is `<mount>/<path>#<key>`, e.g. `secret/backup/mysql#password`
+type Vault struct {
+	client http.Client
+	params Params
+	mu     sync.Mutex
+	cache  map[string]map[string]interface{}
+}
+
+type Params struct {
+	Address     string
+	Token       string
+	Namespace   string
+	KVVersion   int
+	InsecureTLS bool
+	Timeout     time.Duration
+}
+
+type kvResp struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+func Init(params Params) (*Vault, error) {
+
+	if params.Token == "" {
+		params.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if params.Token == "" {
+		return nil, fmt.Errorf("Failed to init Vault secret provider. Token isn't defined ")
+	}
+	if params.KVVersion != 1 && params.KVVersion != 2 {
+		return nil, fmt.Errorf("Failed to init Vault secret provider. Unsupported KV version `%d` ", params.KVVersion)
+	}
+	params.Address = strings.TrimSuffix(params.Address, "/")
+
+	return &Vault{
+		client: http.Client{
+			Timeout: params.Timeout * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: params.InsecureTLS},
+			},
+		},
+		params: params,
+		cache:  make(map[string]map[string]interface{}),
+	}, nil
+}
+
+func (v *Vault) GetName() string {
+	return "vault"
+}
+
+func (v *Vault) GetSecret(ref string) (string, error) {
+	secretPath, key, found := strings.Cut(ref, "#")
+	if !found || key == "" {
+		return "", fmt.Errorf("wrong Vault secret reference `%s`, expected `<mount>/<path>#<key>`", ref)
+	}
+
+	data, err := v.readSecret(secretPath)
+	if err != nil {
+		return "", err
+	}
+
+	val, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("key `%s` not found in Vault secret `%s`", key, secretPath)
+	}
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("value of key `%s` in Vault secret `%s` isn't a string", key, secretPath)
+	}
+
+	return s, nil
+}
+
+func (v *Vault) readSecret(secretPath string) (map[string]interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if data, ok := v.cache[secretPath]; ok {
+		return data, nil
+	}
+
+	apiPath := secretPath
+	if v.params.KVVersion == 2 {
+		mount, p, found := strings.Cut(secretPath, "/")
+		if !found {
+			return nil, fmt.Errorf("wrong Vault secret path `%s`, expected `<mount>/<path>`", secretPath)
+		}
+		apiPath = mount + "/data/" + p
+	}
+
+	req, err := http.NewRequest(http.MethodGet, v.params.Address+"/v1/"+apiPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.params.Token)
+	if v.params.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.params.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var r kvResp
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("unable to decode Vault response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to read Vault secret `%s`: %s %s", secretPath, resp.Status, strings.Join(r.Errors, "; "))
+	}
+
+	data := r.Data
+	if v.params.KVVersion == 2 {
+		// KV v2 wraps the secret data together with metadata
+		inner, ok := r.Data["data"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Vault secret `%s` has no data", secretPath)
+		}
+		data = inner
+	}
+	v.cache[secretPath] = data
+
+	return data, nil
+}
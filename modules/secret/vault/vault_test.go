@@ -0,0 +1,68 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// TestGetSecret runs against Vault dev server (`vault server -dev`), which has KV v2 engine mounted at `secret/`.
+// The test is skipped if VAULT_ADDR and VAULT_TOKEN aren't set
+func TestGetSecret(t *testing.T) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		t.Skip("VAULT_ADDR and VAULT_TOKEN aren't set")
+	}
+
+	writeSecret(t, addr, token, "secret/data/nxs-backup-test/mysql", map[string]interface{}{"password": "s3cr3t", "port": 3306})
+
+	v, err := Init(Params{Address: addr, Token: token, KVVersion: 2, Timeout: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "secret/nxs-backup-test/mysql#password", want: "s3cr3t"},
+		{ref: "secret/nxs-backup-test/mysql#user", wantErr: true},
+		{ref: "secret/nxs-backup-test/mysql#port", wantErr: true},
+		{ref: "secret/nxs-backup-test/mysql", wantErr: true},
+		{ref: "secret/nxs-backup-test/missing#password", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := v.GetSecret(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetSecret() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeSecret(t *testing.T, addr, token, apiPath string, data map[string]interface{}) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]interface{}{"data": data})
+	req, err := http.NewRequest(http.MethodPost, addr+"/v1/"+apiPath, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("unable to write Vault secret, status %s", resp.Status)
+	}
+}
@@ -0,0 +1,241 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/hashicorp/go-multierror"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/logger"
+	. "nxs-backup/modules/storage"
+)
+
+type azure struct {
+	client     *azblob.Client
+	container  string
+	backupPath string
+	name       string
+	Retention
+}
+
+type Params struct {
+	AccountName string
+	AccountKey  string
+	SASToken    string
+	Container   string
+	Endpoint    string // Blob service URL, e.g. `http://127.0.0.1:10000/devstoreaccount1` for Azurite
+}
+
+func Init(name string, params Params) (*azure, error) {
+
+	serviceURL := params.Endpoint
+	if serviceURL == "" {
+		serviceURL = fmt.Sprintf("https://%s.blob.core.windows.net/", params.AccountName)
+	}
+
+	var (
+		client *azblob.Client
+		err    error
+	)
+	switch {
+	case params.AccountKey != "":
+		var cred *azblob.SharedKeyCredential
+		cred, err = azblob.NewSharedKeyCredential(params.AccountName, params.AccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to init '%s' Azure storage. Error: %v ", name, err)
+		}
+		client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+	case params.SASToken != "":
+		client, err = azblob.NewClientWithNoCredential(strings.TrimSuffix(serviceURL, "/")+"/?"+strings.TrimPrefix(params.SASToken, "?"), nil)
+	default:
+		return nil, fmt.Errorf("Failed to init '%s' Azure storage. Either `account_key` or `sas_token` is required ", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to init '%s' Azure storage. Error: %v ", name, err)
+	}
+
+	return &azure{
+		name:      name,
+		client:    client,
+		container: params.Container,
+	}, nil
+}
+
+func (a *azure) IsLocal() int { return 0 }
+
+func (a *azure) SetBackupPath(path string) {
+	a.backupPath = path
+}
+
+func (a *azure) SetRetention(r Retention) {
+	a.Retention = r
+}
+
+func (a *azure) DeliveryBackup(logCh chan logger.LogRecord, jobName, tmpBackupFile, ofs, bakType string) error {
+	var bakRemPaths, mtdRemPaths []string
+
+	if bakType == misc.IncBackupType {
+		bakRemPaths, mtdRemPaths = GetIncBackupDstList(tmpBackupFile, ofs, a.backupPath)
+	} else {
+		bakRemPaths = GetDescBackupDstList(tmpBackupFile, ofs, a.backupPath, a.Retention)
+	}
+
+	if len(mtdRemPaths) > 0 {
+		if err := a.uploadFile(logCh, jobName, tmpBackupFile+".inc", mtdRemPaths); err != nil {
+			return err
+		}
+	}
+
+	return a.uploadFile(logCh, jobName, tmpBackupFile, bakRemPaths)
+}
+
+func (a *azure) uploadFile(logCh chan logger.LogRecord, jobName, srcPath string, dstPaths []string) error {
+	source, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = source.Close() }()
+
+	for _, dst := range dstPaths {
+		blobName := strings.TrimPrefix(dst, "/")
+		if _, err = a.client.UploadFile(context.Background(), a.container, blobName, source, nil); err != nil {
+			logCh <- logger.Log(jobName, a.name).Errorf("Unable to upload blob '%s' to container %s: %s", blobName, a.container, err)
+			return err
+		}
+		logCh <- logger.Log(jobName, a.name).Infof("Successfully uploaded blob '%s' to container %s", blobName, a.container)
+	}
+
+	return nil
+}
+
+func (a *azure) DeleteOldBackups(logCh chan logger.LogRecord, ofsPartsList []string, jobName, bakType string, full bool) error {
+	var errs *multierror.Error
+
+	curDate := time.Now()
+
+	for _, ofs := range ofsPartsList {
+		prefix := strings.TrimPrefix(path.Join(a.backupPath, ofs), "/") + "/"
+
+		pager := a.client.NewListBlobsFlatPager(a.container, &azblob.ListBlobsFlatOptions{Prefix: &prefix})
+		for pager.More() {
+			resp, err := pager.NextPage(context.Background())
+			if err != nil {
+				logCh <- logger.Log(jobName, a.name).Errorf("Failed get blobs: '%s'", err)
+				errs = multierror.Append(errs, err)
+				break
+			}
+
+			for _, blob := range resp.Segment.BlobItems {
+				if blob.Name == nil {
+					continue
+				}
+				blobName := *blob.Name
+				relPath := strings.TrimPrefix(blobName, prefix)
+
+				if bakType == misc.IncBackupType {
+					if !full && !a.isOutdatedIncBackup(relPath) {
+						continue
+					}
+				} else {
+					if blob.Properties == nil || blob.Properties.LastModified == nil {
+						continue
+					}
+					fileDate := *blob.Properties.LastModified
+
+					var retentionDate time.Time
+					switch {
+					case strings.HasPrefix(relPath, "daily/"):
+						retentionDate = fileDate.AddDate(0, 0, a.Retention.Days)
+					case strings.HasPrefix(relPath, "weekly/"):
+						retentionDate = fileDate.AddDate(0, 0, a.Retention.Weeks*7)
+					case strings.HasPrefix(relPath, "monthly/"):
+						retentionDate = fileDate.AddDate(0, a.Retention.Months, 0)
+					default:
+						continue
+					}
+					retentionDate = retentionDate.Truncate(24 * time.Hour)
+					if !curDate.After(retentionDate) {
+						continue
+					}
+				}
+
+				if _, err = a.client.DeleteBlob(context.Background(), a.container, blobName, nil); err != nil {
+					logCh <- logger.Log(jobName, a.name).Errorf("Failed to delete blob '%s' with next error: %s", blobName, err)
+					errs = multierror.Append(errs, err)
+					continue
+				}
+				logCh <- logger.Log(jobName, a.name).Infof("Deleted old backup blob '%s' in container %s", blobName, a.container)
+			}
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// isOutdatedIncBackup checks if the blob of incremental backup belongs to the month out of the retention.
+// The path is relative to the ofs dir, like `2023/month_01/day_01/backup.tar`
+func (a *azure) isOutdatedIncBackup(relPath string) bool {
+	intMoy, _ := strconv.Atoi(misc.GetDateTimeNow("moy"))
+	lastMonth := intMoy - a.Months
+
+	var year string
+	if lastMonth > 0 {
+		year = misc.GetDateTimeNow("year")
+	} else {
+		year = misc.GetDateTimeNow("previous_year")
+		lastMonth += 12
+	}
+
+	parts := strings.Split(relPath, "/")
+	if len(parts) < 2 || parts[0] != year {
+		return false
+	}
+	if !regexp.MustCompile(`^month_\d\d$`).MatchString(parts[1]) {
+		return false
+	}
+	dirMonth, _ := strconv.Atoi(strings.TrimPrefix(parts[1], "month_"))
+
+	return dirMonth < lastMonth
+}
+
+func (a *azure) GetFileReader(ofsPath string) (io.Reader, error) {
+	blobName := strings.TrimPrefix(path.Join(a.backupPath, ofsPath), "/")
+
+	resp, err := a.client.DownloadStream(context.Background(), a.container, blobName, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var buf []byte
+	buf, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(buf), nil
+}
+
+func (a *azure) Close() error {
+	return nil
+}
+
+func (a *azure) Clone() interfaces.Storage {
+	cl := *a
+	return &cl
+}
+
+func (a *azure) GetName() string {
+	return a.name
+}
@@ -0,0 +1,154 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nxs-backup/misc"
+	"nxs-backup/modules/logger"
+	. "nxs-backup/modules/storage"
+)
+
+// Well-known credentials of the Azurite default account
+const (
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// TestAzurite runs against Azurite (`azurite-blob`), e.g. with AZURITE_ENDPOINT=http://127.0.0.1:10000/devstoreaccount1.
+// The test is skipped if AZURITE_ENDPOINT isn't set
+func TestAzurite(t *testing.T) {
+	endpoint := os.Getenv("AZURITE_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AZURITE_ENDPOINT isn't set")
+	}
+
+	a, err := Init("azurite", Params{
+		AccountName: azuriteAccountName,
+		AccountKey:  azuriteAccountKey,
+		Container:   fmt.Sprintf("nxs-backup-test-%d", time.Now().UnixNano()),
+		Endpoint:    endpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = a.client.CreateContainer(context.Background(), a.container, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = a.client.DeleteContainer(context.Background(), a.container, nil) })
+	a.SetBackupPath("/backups")
+
+	logCh := make(chan logger.LogRecord)
+	go func() {
+		for range logCh {
+		}
+	}()
+	t.Cleanup(func() { close(logCh) })
+
+	t.Run("desc", func(t *testing.T) {
+		tmpFile := writeTmpFile(t, t.TempDir(), "db.sql", "desc backup")
+		a.SetRetention(Retention{Days: 7})
+
+		if err := a.DeliveryBackup(logCh, "test", tmpFile, "desc/db", "desc_files"); err != nil {
+			t.Fatalf("DeliveryBackup() error = %v", err)
+		}
+		assertBlob(t, a, "desc/db/daily/db.sql", "desc backup")
+
+		if err := a.DeleteOldBackups(logCh, []string{"desc/db"}, "test", "desc_files", false); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertBlob(t, a, "desc/db/daily/db.sql", "desc backup")
+
+		// all daily backups are out of the zero days retention
+		a.SetRetention(Retention{Days: 0})
+		if err := a.DeleteOldBackups(logCh, []string{"desc/db"}, "test", "desc_files", false); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertNoBlob(t, a, "desc/db/daily/db.sql")
+	})
+
+	t.Run("inc", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		tmpFile := writeTmpFile(t, tmpDir, "files.tar", "inc backup")
+		writeTmpFile(t, tmpDir, "files.tar.inc", "inc meta")
+		// the first backup of the chain is delivered with all metadata files
+		writeTmpFile(t, tmpDir, "files.tar.init", "")
+		a.SetRetention(Retention{Months: 0})
+
+		if err := a.DeliveryBackup(logCh, "test", tmpFile, "inc/files", misc.IncBackupType); err != nil {
+			t.Fatalf("DeliveryBackup() error = %v", err)
+		}
+		year := misc.GetDateTimeNow("year")
+		month := fmt.Sprintf("month_%02s", misc.GetDateTimeNow("moy"))
+		dayPath := path.Join("inc/files", year, month, misc.GetDecadeDaySubdir(), "files.tar")
+		assertBlob(t, a, dayPath, "inc backup")
+		assertBlob(t, a, path.Join("inc/files", year, "inc_meta_info/day.inc"), "inc meta")
+
+		// month_00 precedes any month of the current year, so it's out of the retention
+		oldPath := path.Join("inc/files", year, "month_00/day_01/files.tar")
+		uploadBlob(t, a, oldPath, "old inc backup")
+
+		if err := a.DeleteOldBackups(logCh, []string{"inc/files"}, "test", misc.IncBackupType, false); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertNoBlob(t, a, oldPath)
+		assertBlob(t, a, dayPath, "inc backup")
+
+		if err := a.DeleteOldBackups(logCh, []string{"inc/files"}, "test", misc.IncBackupType, true); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertNoBlob(t, a, dayPath)
+		assertNoBlob(t, a, path.Join("inc/files", year, "inc_meta_info/day.inc"))
+	})
+}
+
+func writeTmpFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func uploadBlob(t *testing.T, a *azure, ofsPath, content string) {
+	t.Helper()
+
+	blobName := strings.TrimPrefix(path.Join(a.backupPath, ofsPath), "/")
+	if _, err := a.client.UploadBuffer(context.Background(), a.container, blobName, []byte(content), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertBlob(t *testing.T, a *azure, ofsPath, want string) {
+	t.Helper()
+
+	reader, err := a.GetFileReader(ofsPath)
+	if err != nil {
+		t.Fatalf("GetFileReader(%s) error = %v", ofsPath, err)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("GetFileReader(%s) = %q, want %q", ofsPath, got, want)
+	}
+}
+
+func assertNoBlob(t *testing.T, a *azure, ofsPath string) {
+	t.Helper()
+
+	if _, err := a.GetFileReader(ofsPath); err == nil {
+		t.Errorf("GetFileReader(%s) succeeded, blob should be deleted", ofsPath)
+	}
+}
@@ -16,6 +16,11 @@ type Retention struct {
 	Months int
 }
 
+// GetRetention returns the retention periods of the storage the struct is embedded into
+func (r Retention) GetRetention() Retention {
+	return r
+}
+
 func GetNeedToMakeBackup(day, week, month int) bool {
 
 	if day > 0 ||
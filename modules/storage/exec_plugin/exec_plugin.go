@@ -0,0 +1,298 @@
+package exec_plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/logger"
+	. "nxs-backup/modules/storage"
+)
+
+// protocolVersion is the version of the plugin protocol passed in each request
+const protocolVersion = 1
+
+const (
+	opPut    = "put"
+	opGet    = "get"
+	opList   = "list"
+	opDelete = "delete"
+)
+
+type execPlugin struct {
+	name       string
+	cmd        string
+	args       []string
+	envs       map[string]string
+	timeout    time.Duration
+	backupPath string
+	Retention
+}
+
+type Params struct {
+	Cmd     string
+	Args    []string
+	Envs    map[string]string
+	Timeout time.Duration
+}
+
+// request is the JSON object written to the plugin stdin. Paths of objects start with the storage `backup_path`
+type request struct {
+	Version int      `json:"version"`
+	Op      string   `json:"op"`
+	Src     string   `json:"src,omitempty"`   // put: local file to upload
+	Dst     string   `json:"dst,omitempty"`   // put: object path, get: local file to download to
+	Path    string   `json:"path,omitempty"`  // get: object path, list: prefix of objects
+	Paths   []string `json:"paths,omitempty"` // delete: object paths
+}
+
+// response is the JSON object the plugin writes to stdout. Empty stdout is treated as a success
+type response struct {
+	Error   string   `json:"error"`
+	Objects []object `json:"objects"`
+}
+
+// object is the file found by list request. The path has the same form as the requested prefix
+type object struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+func Init(name string, params Params) (*execPlugin, error) {
+
+	if _, err := exec.LookPath(params.Cmd); err != nil {
+		return nil, fmt.Errorf("Failed to init '%s' exec storage. Error: %v ", name, err)
+	}
+
+	return &execPlugin{
+		name:    name,
+		cmd:     params.Cmd,
+		args:    params.Args,
+		envs:    params.Envs,
+		timeout: params.Timeout,
+	}, nil
+}
+
+func (p *execPlugin) IsLocal() int { return 0 }
+
+func (p *execPlugin) SetBackupPath(path string) {
+	p.backupPath = path
+}
+
+func (p *execPlugin) SetRetention(r Retention) {
+	p.Retention = r
+}
+
+func (p *execPlugin) DeliveryBackup(logCh chan logger.LogRecord, jobName, tmpBackupFile, ofs, bakType string) error {
+	var bakRemPaths, mtdRemPaths []string
+
+	if bakType == misc.IncBackupType {
+		bakRemPaths, mtdRemPaths = GetIncBackupDstList(tmpBackupFile, ofs, p.backupPath)
+	} else {
+		bakRemPaths = GetDescBackupDstList(tmpBackupFile, ofs, p.backupPath, p.Retention)
+	}
+
+	for _, dst := range mtdRemPaths {
+		if _, err := p.run(request{Op: opPut, Src: tmpBackupFile + ".inc", Dst: dst}); err != nil {
+			logCh <- logger.Log(jobName, p.name).Errorf("Unable to upload file '%s': %s", dst, err)
+			return err
+		}
+		logCh <- logger.Log(jobName, p.name).Infof("Successfully uploaded file '%s'", dst)
+	}
+
+	for _, dst := range bakRemPaths {
+		if _, err := p.run(request{Op: opPut, Src: tmpBackupFile, Dst: dst}); err != nil {
+			logCh <- logger.Log(jobName, p.name).Errorf("Unable to upload file '%s': %s", dst, err)
+			return err
+		}
+		logCh <- logger.Log(jobName, p.name).Infof("Successfully uploaded file '%s'", dst)
+	}
+
+	return nil
+}
+
+func (p *execPlugin) DeleteOldBackups(logCh chan logger.LogRecord, ofsPartsList []string, jobName, bakType string, full bool) error {
+	var errs *multierror.Error
+
+	curDate := time.Now()
+
+	for _, ofs := range ofsPartsList {
+		backupDir := path.Join(p.backupPath, ofs)
+
+		// the trailing slash prevents matching of sibling dirs with the same prefix by plugins listing objects by prefix
+		resp, err := p.run(request{Op: opList, Path: backupDir + "/"})
+		if err != nil {
+			logCh <- logger.Log(jobName, p.name).Errorf("Failed to list files in '%s': %s", backupDir, err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		var toDelete []string
+		for _, obj := range resp.Objects {
+			if !strings.HasPrefix(obj.Path, backupDir+"/") {
+				continue
+			}
+			relPath := strings.TrimPrefix(obj.Path, backupDir+"/")
+
+			if bakType == misc.IncBackupType {
+				if full || p.isOutdatedIncBackup(relPath) {
+					toDelete = append(toDelete, obj.Path)
+				}
+				continue
+			}
+
+			var retentionDate time.Time
+			switch {
+			case strings.Contains(relPath, "daily/"):
+				retentionDate = obj.ModTime.AddDate(0, 0, p.Retention.Days)
+			case strings.Contains(relPath, "weekly/"):
+				retentionDate = obj.ModTime.AddDate(0, 0, p.Retention.Weeks*7)
+			case strings.Contains(relPath, "monthly/"):
+				retentionDate = obj.ModTime.AddDate(0, p.Retention.Months, 0)
+			default:
+				continue
+			}
+			retentionDate = retentionDate.Truncate(24 * time.Hour)
+			if curDate.After(retentionDate) {
+				toDelete = append(toDelete, obj.Path)
+			}
+		}
+
+		if len(toDelete) == 0 {
+			continue
+		}
+		if _, err = p.run(request{Op: opDelete, Paths: toDelete}); err != nil {
+			logCh <- logger.Log(jobName, p.name).Errorf("Failed to delete old backups in '%s': %s", backupDir, err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		for _, f := range toDelete {
+			logCh <- logger.Log(jobName, p.name).Infof("Deleted old backup file '%s'", f)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// isOutdatedIncBackup checks if the file of incremental backup belongs to the month out of the retention.
+// The path is relative to the ofs dir, like `2023/month_01/day_01/backup.tar`
+func (p *execPlugin) isOutdatedIncBackup(relPath string) bool {
+	intMoy, _ := strconv.Atoi(misc.GetDateTimeNow("moy"))
+	lastMonth := intMoy - p.Months
+
+	var year string
+	if lastMonth > 0 {
+		year = misc.GetDateTimeNow("year")
+	} else {
+		year = misc.GetDateTimeNow("previous_year")
+		lastMonth += 12
+	}
+
+	parts := strings.Split(relPath, "/")
+	if len(parts) < 2 || parts[0] != year {
+		return false
+	}
+	if !regexp.MustCompile(`^month_\d\d$`).MatchString(parts[1]) {
+		return false
+	}
+	dirMonth, _ := strconv.Atoi(strings.TrimPrefix(parts[1], "month_"))
+
+	return dirMonth < lastMonth
+}
+
+func (p *execPlugin) GetFileReader(ofsPath string) (io.Reader, error) {
+	tmpDir, err := misc.GetPrivateTmpDir()
+	if err != nil {
+		return nil, err
+	}
+
+	tmpFile, err := os.CreateTemp(tmpDir, "exec_storage_*")
+	if err != nil {
+		return nil, err
+	}
+	_ = tmpFile.Close()
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err = p.run(request{Op: opGet, Path: path.Join(p.backupPath, ofsPath), Dst: tmpFile.Name()}); err != nil {
+		return nil, err
+	}
+
+	buf, err := os.ReadFile(tmpFile.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(buf), nil
+}
+
+// run executes the plugin with the request passed to stdin and returns its parsed response
+func (p *execPlugin) run(req request) (*response, error) {
+	var stdout, stderr bytes.Buffer
+
+	req.Version = protocolVersion
+	reqData, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout*time.Second)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, p.cmd, p.args...)
+	cmd.Stdin = bytes.NewReader(reqData)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if len(p.envs) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range p.envs {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("plugin `%s` %s failed: %s %s", p.cmd, req.Op, err, strings.TrimSpace(stderr.String()))
+	}
+
+	resp := &response{}
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 {
+		if err = json.Unmarshal(out, resp); err != nil {
+			return nil, fmt.Errorf("unable to parse response of plugin `%s` %s: %s", p.cmd, req.Op, err)
+		}
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin `%s` %s failed: %s", p.cmd, req.Op, resp.Error)
+	}
+
+	return resp, nil
+}
+
+func (p *execPlugin) Close() error {
+	return nil
+}
+
+func (p *execPlugin) Clone() interfaces.Storage {
+	cl := *p
+	return &cl
+}
+
+func (p *execPlugin) GetName() string {
+	return p.name
+}
@@ -0,0 +1,457 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/oauth2/jwt"
+
+	"nxs-backup/interfaces"
+	"nxs-backup/misc"
+	"nxs-backup/modules/logger"
+	. "nxs-backup/modules/storage"
+)
+
+const (
+	defaultEndpoint = "https://storage.googleapis.com"
+	defaultTokenURL = "https://oauth2.googleapis.com/token"
+	readWriteScope  = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// chunkSize is the size of resumable upload requests. It must be a multiple of 256 KiB
+	chunkSize = 16 * 1024 * 1024
+	// chunkRetries is the number of attempts to upload the chunk before the upload fails
+	chunkRetries = 3
+)
+
+type gcs struct {
+	client     *http.Client
+	endpoint   string
+	bucket     string
+	backupPath string
+	name       string
+	Retention
+}
+
+type Params struct {
+	Bucket          string
+	CredentialsFile string // Service account JSON key file
+	Endpoint        string // JSON API URL, e.g. `http://127.0.0.1:4443` for fake-gcs-server
+}
+
+type serviceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type object struct {
+	Name    string    `json:"name"`
+	Updated time.Time `json:"updated"`
+}
+
+type objectsList struct {
+	Items         []object `json:"items"`
+	NextPageToken string   `json:"nextPageToken"`
+}
+
+func Init(name string, params Params) (*gcs, error) {
+
+	g := &gcs{
+		name:     name,
+		client:   http.DefaultClient,
+		endpoint: strings.TrimSuffix(params.Endpoint, "/"),
+		bucket:   params.Bucket,
+	}
+	if g.endpoint == "" {
+		g.endpoint = defaultEndpoint
+	}
+
+	if params.CredentialsFile != "" {
+		data, err := os.ReadFile(params.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to init '%s' GCS storage. Unable to read credentials file. Error: %v ", name, err)
+		}
+		var sa serviceAccount
+		if err = json.Unmarshal(data, &sa); err != nil {
+			return nil, fmt.Errorf("Failed to init '%s' GCS storage. Unable to parse credentials file. Error: %v ", name, err)
+		}
+		if sa.TokenURI == "" {
+			sa.TokenURI = defaultTokenURL
+		}
+		conf := &jwt.Config{
+			Email:        sa.ClientEmail,
+			PrivateKey:   []byte(sa.PrivateKey),
+			PrivateKeyID: sa.PrivateKeyID,
+			Scopes:       []string{readWriteScope},
+			TokenURL:     sa.TokenURI,
+		}
+		g.client = conf.Client(context.Background())
+	} else if params.Endpoint == "" {
+		return nil, fmt.Errorf("Failed to init '%s' GCS storage. `credentials_file` is required ", name)
+	}
+
+	return g, nil
+}
+
+func (g *gcs) IsLocal() int { return 0 }
+
+func (g *gcs) SetBackupPath(path string) {
+	g.backupPath = path
+}
+
+func (g *gcs) SetRetention(r Retention) {
+	g.Retention = r
+}
+
+func (g *gcs) DeliveryBackup(logCh chan logger.LogRecord, jobName, tmpBackupFile, ofs, bakType string) error {
+	var bakRemPaths, mtdRemPaths []string
+
+	if bakType == misc.IncBackupType {
+		bakRemPaths, mtdRemPaths = GetIncBackupDstList(tmpBackupFile, ofs, g.backupPath)
+	} else {
+		bakRemPaths = GetDescBackupDstList(tmpBackupFile, ofs, g.backupPath, g.Retention)
+	}
+
+	for _, dst := range mtdRemPaths {
+		objName := strings.TrimPrefix(dst, "/")
+		if err := g.upload(tmpBackupFile+".inc", objName); err != nil {
+			logCh <- logger.Log(jobName, g.name).Errorf("Unable to upload object '%s' to bucket %s: %s", objName, g.bucket, err)
+			return err
+		}
+		logCh <- logger.Log(jobName, g.name).Infof("Successfully uploaded object '%s' in bucket %s", objName, g.bucket)
+	}
+
+	for _, dst := range bakRemPaths {
+		objName := strings.TrimPrefix(dst, "/")
+		if err := g.upload(tmpBackupFile, objName); err != nil {
+			logCh <- logger.Log(jobName, g.name).Errorf("Unable to upload object '%s' to bucket %s: %s", objName, g.bucket, err)
+			return err
+		}
+		logCh <- logger.Log(jobName, g.name).Infof("Successfully uploaded object '%s' in bucket %s", objName, g.bucket)
+	}
+
+	return nil
+}
+
+// upload sends the file with the resumable upload. Failed chunks are retried from the offset persisted by GCS
+func (g *gcs) upload(srcPath, objName string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	size := stat.Size()
+
+	sessionURL, err := g.startUpload(objName, size)
+	if err != nil {
+		return err
+	}
+
+	if size == 0 {
+		_, err = g.putChunk(sessionURL, nil, "bytes */0")
+		return err
+	}
+
+	var offset int64
+	buf := make([]byte, chunkSize)
+	for offset < size {
+		n, err := src.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		end := offset + int64(n) - 1
+
+		var committed int64
+		for attempt := 1; ; attempt++ {
+			committed, err = g.putChunk(sessionURL, buf[:n], fmt.Sprintf("bytes %d-%d/%d", offset, end, size))
+			if err == nil {
+				break
+			}
+			if attempt == chunkRetries {
+				return err
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+			// the chunk may be partially persisted, so the upload continues from the committed offset
+			if committed, err = g.putChunk(sessionURL, nil, fmt.Sprintf("bytes */%d", size)); err != nil {
+				return err
+			}
+			if committed > offset {
+				break
+			}
+		}
+		if committed <= offset {
+			return fmt.Errorf("upload of `%s` made no progress at offset %d", objName, offset)
+		}
+		offset = committed
+	}
+
+	return nil
+}
+
+// startUpload initiates the resumable upload session and returns its URL
+func (g *gcs) startUpload(objName string, size int64) (string, error) {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s", g.endpoint, url.PathEscape(g.bucket), url.QueryEscape(objName))
+
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader("{}"))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("X-Upload-Content-Type", "application/octet-stream")
+	req.Header.Set("X-Upload-Content-Length", strconv.FormatInt(size, 10))
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", respError(resp)
+	}
+	sessionURL := resp.Header.Get("Location")
+	if sessionURL == "" {
+		return "", fmt.Errorf("no upload session URL in the response")
+	}
+
+	return sessionURL, nil
+}
+
+// putChunk sends the chunk of the resumable upload and returns the count of bytes persisted by GCS
+func (g *gcs) putChunk(sessionURL string, chunk []byte, contentRange string) (int64, error) {
+	req, err := http.NewRequest(http.MethodPut, sessionURL, bytes.NewReader(chunk))
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = int64(len(chunk))
+	req.Header.Set("Content-Range", contentRange)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		// the upload is completed
+		return chunkEnd(contentRange), nil
+	case http.StatusPermanentRedirect:
+		// the upload is incomplete, `Range: bytes=0-N` contains the persisted bytes
+		var committed int64
+		if r := resp.Header.Get("Range"); r != "" {
+			if i := strings.LastIndex(r, "-"); i >= 0 {
+				last, err := strconv.ParseInt(r[i+1:], 10, 64)
+				if err != nil {
+					return 0, fmt.Errorf("unexpected range `%s` in the response", r)
+				}
+				committed = last + 1
+			}
+		}
+		return committed, nil
+	default:
+		return 0, respError(resp)
+	}
+}
+
+// chunkEnd returns the total size from the Content-Range header value like `bytes 0-99/100`
+func chunkEnd(contentRange string) int64 {
+	total, _ := strconv.ParseInt(contentRange[strings.LastIndex(contentRange, "/")+1:], 10, 64)
+	return total
+}
+
+func (g *gcs) DeleteOldBackups(logCh chan logger.LogRecord, ofsPartsList []string, jobName, bakType string, full bool) error {
+	var errs *multierror.Error
+
+	curDate := time.Now()
+
+	for _, ofs := range ofsPartsList {
+		prefix := strings.TrimPrefix(path.Join(g.backupPath, ofs), "/") + "/"
+
+		objects, err := g.list(prefix)
+		if err != nil {
+			logCh <- logger.Log(jobName, g.name).Errorf("Failed get objects: '%s'", err)
+			errs = multierror.Append(errs, err)
+			continue
+		}
+
+		for _, obj := range objects {
+			relPath := strings.TrimPrefix(obj.Name, prefix)
+
+			if bakType == misc.IncBackupType {
+				if !full && !g.isOutdatedIncBackup(relPath) {
+					continue
+				}
+			} else {
+				var retentionDate time.Time
+				switch {
+				case strings.HasPrefix(relPath, "daily/"):
+					retentionDate = obj.Updated.AddDate(0, 0, g.Retention.Days)
+				case strings.HasPrefix(relPath, "weekly/"):
+					retentionDate = obj.Updated.AddDate(0, 0, g.Retention.Weeks*7)
+				case strings.HasPrefix(relPath, "monthly/"):
+					retentionDate = obj.Updated.AddDate(0, g.Retention.Months, 0)
+				default:
+					continue
+				}
+				retentionDate = retentionDate.Truncate(24 * time.Hour)
+				if !curDate.After(retentionDate) {
+					continue
+				}
+			}
+
+			if err = g.delete(obj.Name); err != nil {
+				logCh <- logger.Log(jobName, g.name).Errorf("Failed to delete object '%s' with next error: %s", obj.Name, err)
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			logCh <- logger.Log(jobName, g.name).Infof("Deleted old backup object '%s' in bucket %s", obj.Name, g.bucket)
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// isOutdatedIncBackup checks if the object of incremental backup belongs to the month out of the retention.
+// The path is relative to the ofs dir, like `2023/month_01/day_01/backup.tar`
+func (g *gcs) isOutdatedIncBackup(relPath string) bool {
+	intMoy, _ := strconv.Atoi(misc.GetDateTimeNow("moy"))
+	lastMonth := intMoy - g.Months
+
+	var year string
+	if lastMonth > 0 {
+		year = misc.GetDateTimeNow("year")
+	} else {
+		year = misc.GetDateTimeNow("previous_year")
+		lastMonth += 12
+	}
+
+	parts := strings.Split(relPath, "/")
+	if len(parts) < 2 || parts[0] != year {
+		return false
+	}
+	if !regexp.MustCompile(`^month_\d\d$`).MatchString(parts[1]) {
+		return false
+	}
+	dirMonth, _ := strconv.Atoi(strings.TrimPrefix(parts[1], "month_"))
+
+	return dirMonth < lastMonth
+}
+
+func (g *gcs) list(prefix string) ([]object, error) {
+	var objects []object
+
+	pageToken := ""
+	for {
+		q := url.Values{}
+		q.Set("prefix", prefix)
+		q.Set("fields", "items(name,updated),nextPageToken")
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		resp, err := g.client.Get(fmt.Sprintf("%s/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), q.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = respError(resp)
+			_ = resp.Body.Close()
+			return nil, err
+		}
+
+		var l objectsList
+		err = json.NewDecoder(resp.Body).Decode(&l)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, l.Items...)
+		if l.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = l.NextPageToken
+	}
+}
+
+func (g *gcs) delete(objName string) error {
+	req, err := http.NewRequest(http.MethodDelete, g.objectURL(objName), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return respError(resp)
+	}
+
+	return nil
+}
+
+func (g *gcs) GetFileReader(ofsPath string) (io.Reader, error) {
+	objName := strings.TrimPrefix(path.Join(g.backupPath, ofsPath), "/")
+
+	resp, err := g.client.Get(g.objectURL(objName) + "?alt=media")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, respError(resp)
+	}
+
+	var buf []byte
+	buf, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(buf), nil
+}
+
+func (g *gcs) objectURL(objName string) string {
+	return fmt.Sprintf("%s/storage/v1/b/%s/o/%s", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(objName))
+}
+
+func respError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("unexpected response status `%s`: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+func (g *gcs) Close() error {
+	return nil
+}
+
+func (g *gcs) Clone() interfaces.Storage {
+	cl := *g
+	return &cl
+}
+
+func (g *gcs) GetName() string {
+	return g.name
+}
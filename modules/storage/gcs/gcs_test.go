@@ -0,0 +1,310 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"nxs-backup/misc"
+	"nxs-backup/modules/logger"
+	. "nxs-backup/modules/storage"
+)
+
+// fakeUpload emulates the resumable upload session of GCS JSON API
+type fakeUpload struct {
+	sync.Mutex
+	data []byte
+	// partial is the count of bytes of the first chunk persisted by the server, the rest must be resent
+	partial int
+	// failures is the count of chunk requests to be failed with 503
+	failures int
+	requests int
+}
+
+func (f *fakeUpload) handler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload/storage/v1/b/bucket/o", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Query().Get("uploadType") != "resumable" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", "http://"+r.Host+"/session")
+	})
+	mux.HandleFunc("/session", func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		f.requests++
+
+		body, _ := io.ReadAll(r.Body)
+		cr := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+		total, err := strconv.Atoi(cr[strings.LastIndex(cr, "/")+1:])
+		if err != nil {
+			t.Errorf("unexpected Content-Range: %s", cr)
+			http.Error(w, "bad range", http.StatusBadRequest)
+			return
+		}
+
+		// the status request
+		if strings.HasPrefix(cr, "*/") {
+			f.writeStatus(w, total)
+			return
+		}
+
+		if f.failures > 0 {
+			f.failures--
+			http.Error(w, "backend error", http.StatusServiceUnavailable)
+			return
+		}
+
+		var start int
+		_, _ = fmt.Sscanf(cr, "%d-", &start)
+		if start != len(f.data) {
+			t.Errorf("chunk starts at %d, but %d bytes are persisted", start, len(f.data))
+			http.Error(w, "bad offset", http.StatusBadRequest)
+			return
+		}
+		if f.partial > 0 && f.partial < len(body) {
+			body = body[:f.partial]
+			f.partial = 0
+		}
+		f.data = append(f.data, body...)
+		f.writeStatus(w, total)
+	})
+
+	return mux
+}
+
+func (f *fakeUpload) writeStatus(w http.ResponseWriter, total int) {
+	if len(f.data) == total {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if len(f.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(f.data)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+func TestUpload(t *testing.T) {
+	src := make([]byte, 2*chunkSize+1000)
+	rand.New(rand.NewSource(1)).Read(src)
+	srcFile := filepath.Join(t.TempDir(), "backup.tar")
+	if err := os.WriteFile(srcFile, src, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		upload   *fakeUpload
+		requests int
+		wantErr  bool
+	}{
+		{
+			name:     "multi-chunk",
+			upload:   &fakeUpload{},
+			requests: 3,
+		},
+		{
+			// the rest of the first chunk is sent at the beginning of the next one
+			name:     "partially persisted chunk",
+			upload:   &fakeUpload{partial: 1024 * 1024},
+			requests: 3,
+		},
+		{
+			// the failed chunk is resent after the status request
+			name:     "retry of failed chunk",
+			upload:   &fakeUpload{failures: 1},
+			requests: 5,
+		},
+		{
+			name:    "retries exceeded",
+			upload:  &fakeUpload{failures: chunkRetries},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.upload.handler(t))
+			defer srv.Close()
+
+			g := &gcs{client: srv.Client(), endpoint: srv.URL, bucket: "bucket"}
+			err := g.upload(srcFile, "backups/backup.tar")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("upload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !bytes.Equal(tt.upload.data, src) {
+				t.Errorf("uploaded %d bytes differ from the source of %d bytes", len(tt.upload.data), len(src))
+			}
+			if tt.upload.requests != tt.requests {
+				t.Errorf("upload made %d chunk requests, want %d", tt.upload.requests, tt.requests)
+			}
+		})
+	}
+}
+
+// TestFakeGCS runs against fake-gcs-server, e.g. started with `fake-gcs-server -scheme http -port 4443
+// -public-host 127.0.0.1:4443` and FAKE_GCS_ENDPOINT=http://127.0.0.1:4443. The test is skipped if FAKE_GCS_ENDPOINT
+// isn't set
+func TestFakeGCS(t *testing.T) {
+	endpoint := os.Getenv("FAKE_GCS_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("FAKE_GCS_ENDPOINT isn't set")
+	}
+
+	g, err := Init("fake-gcs", Params{
+		Bucket:   fmt.Sprintf("nxs-backup-test-%d", time.Now().UnixNano()),
+		Endpoint: endpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	createBucket(t, g)
+	t.Cleanup(func() { deleteBucket(g) })
+	g.SetBackupPath("/backups")
+
+	logCh := make(chan logger.LogRecord)
+	go func() {
+		for range logCh {
+		}
+	}()
+	t.Cleanup(func() { close(logCh) })
+
+	t.Run("desc", func(t *testing.T) {
+		tmpFile := writeTmpFile(t, t.TempDir(), "db.sql", "desc backup")
+		g.SetRetention(Retention{Days: 7})
+
+		if err := g.DeliveryBackup(logCh, "test", tmpFile, "desc/db", "desc_files"); err != nil {
+			t.Fatalf("DeliveryBackup() error = %v", err)
+		}
+		assertObject(t, g, "desc/db/daily/db.sql", "desc backup")
+
+		if err := g.DeleteOldBackups(logCh, []string{"desc/db"}, "test", "desc_files", false); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertObject(t, g, "desc/db/daily/db.sql", "desc backup")
+
+		// all daily backups are out of the zero days retention
+		g.SetRetention(Retention{Days: 0})
+		if err := g.DeleteOldBackups(logCh, []string{"desc/db"}, "test", "desc_files", false); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertNoObject(t, g, "desc/db/daily/db.sql")
+	})
+
+	t.Run("inc", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		tmpFile := writeTmpFile(t, tmpDir, "files.tar", "inc backup")
+		writeTmpFile(t, tmpDir, "files.tar.inc", "inc meta")
+		// the first backup of the chain is delivered with all metadata files
+		writeTmpFile(t, tmpDir, "files.tar.init", "")
+		g.SetRetention(Retention{Months: 0})
+
+		if err := g.DeliveryBackup(logCh, "test", tmpFile, "inc/files", misc.IncBackupType); err != nil {
+			t.Fatalf("DeliveryBackup() error = %v", err)
+		}
+		year := misc.GetDateTimeNow("year")
+		month := fmt.Sprintf("month_%02s", misc.GetDateTimeNow("moy"))
+		dayPath := path.Join("inc/files", year, month, misc.GetDecadeDaySubdir(), "files.tar")
+		assertObject(t, g, dayPath, "inc backup")
+		assertObject(t, g, path.Join("inc/files", year, "inc_meta_info/day.inc"), "inc meta")
+
+		// month_00 precedes any month of the current year, so it's out of the retention
+		oldPath := path.Join("inc/files", year, "month_00/day_01/files.tar")
+		if err := g.upload(writeTmpFile(t, tmpDir, "old.tar", "old inc backup"), "backups/"+oldPath); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.DeleteOldBackups(logCh, []string{"inc/files"}, "test", misc.IncBackupType, false); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertNoObject(t, g, oldPath)
+		assertObject(t, g, dayPath, "inc backup")
+
+		if err := g.DeleteOldBackups(logCh, []string{"inc/files"}, "test", misc.IncBackupType, true); err != nil {
+			t.Fatalf("DeleteOldBackups() error = %v", err)
+		}
+		assertNoObject(t, g, dayPath)
+		assertNoObject(t, g, path.Join("inc/files", year, "inc_meta_info/day.inc"))
+	})
+}
+
+func createBucket(t *testing.T, g *gcs) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"name": g.bucket})
+	resp, err := g.client.Post(g.endpoint+"/storage/v1/b", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal(respError(resp))
+	}
+}
+
+func deleteBucket(g *gcs) {
+	objects, _ := g.list("")
+	for _, obj := range objects {
+		_ = g.delete(obj.Name)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, g.endpoint+"/storage/v1/b/"+url.PathEscape(g.bucket), nil)
+	if err != nil {
+		return
+	}
+	if resp, err := g.client.Do(req); err == nil {
+		_ = resp.Body.Close()
+	}
+}
+
+func writeTmpFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func assertObject(t *testing.T, g *gcs, ofsPath, want string) {
+	t.Helper()
+
+	reader, err := g.GetFileReader(ofsPath)
+	if err != nil {
+		t.Fatalf("GetFileReader(%s) error = %v", ofsPath, err)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("GetFileReader(%s) = %q, want %q", ofsPath, got, want)
+	}
+}
+
+func assertNoObject(t *testing.T, g *gcs, ofsPath string) {
+	t.Helper()
+
+	if _, err := g.GetFileReader(ofsPath); err == nil {
+		t.Errorf("GetFileReader(%s) succeeded, object should be deleted", ofsPath)
+	}
+}
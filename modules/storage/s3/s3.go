@@ -41,10 +41,7 @@ type Params struct {
 
 func Init(name string, params Params) (*s3, error) {
 
-	s3Client, err := minio.New(params.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(params.AccessKeyID, params.SecretKey, ""),
-		Secure: params.Secure,
-	})
+	s3Client, err := NewClient(params)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to init '%s' S3 storage. Error: %v ", name, err)
 	}
@@ -56,6 +53,14 @@ func Init(name string, params Params) (*s3, error) {
 	}, nil
 }
 
+// NewClient returns S3 client. It is also used by jobs backing up S3 buckets
+func NewClient(params Params) (*minio.Client, error) {
+	return minio.New(params.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(params.AccessKeyID, params.SecretKey, ""),
+		Secure: params.Secure,
+	})
+}
+
 func (s *s3) IsLocal() int { return 0 }
 
 func (s *s3) SetBackupPath(path string) {
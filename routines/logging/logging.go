@@ -17,6 +17,7 @@ func Runtime(c context.Context, appCtx *appctx.AppContext, crc chan interface{})
 	for {
 		select {
 		case log := <-cc.LogCh:
+			log = log.Masked()
 			logger.WriteLog(appCtx.Log(), log)
 			for _, n := range cc.Notifiers {
 				go n.Send(appCtx, log, cc.WG)